@@ -12,7 +12,7 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
-	elevation, err := srtm.GetElevation(http.DefaultClient, 45.2775, 13.726111)
+	elevation, err := srtm.GetElevation(45.2775, 13.726111)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -0,0 +1,172 @@
+package geoelevations
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Point is a coordinate (in degrees)
+type Point struct {
+	Lat, Lon float64
+}
+
+// pointPairs converts points to {latitude, longitude} pairs
+func pointPairs(points []Point) [][2]float64 {
+	result := make([][2]float64, len(points))
+	for n, point := range points {
+		result[n] = [2]float64{point.Lat, point.Lon}
+	}
+	return result
+}
+
+func validateCoordinates(latitude, longitude float64) error {
+	if math.IsNaN(latitude) || math.IsNaN(longitude) || math.IsInf(latitude, 0) || math.IsInf(longitude, 0) {
+		return fmt.Errorf("%w: %v,%v", ErrInvalidCoordinate, latitude, longitude)
+	}
+	if latitude < -90 || latitude > 90 {
+		return fmt.Errorf("%w: latitude %v not in [-90, 90]", ErrInvalidCoordinate, latitude)
+	}
+	// Longitudes in the [0, 360] range are accepted, too, see normalizeLongitude
+	if longitude < -180 || longitude > 360 {
+		return fmt.Errorf("%w: longitude %v not in [-180, 360]", ErrInvalidCoordinate, longitude)
+	}
+	return nil
+}
+
+// SRTM covers latitudes between 56°S and 60°N
+const (
+	srtmMinLatitude = -56
+	srtmMaxLatitude = 60
+)
+
+func isInSrtmCoverage(latitude float64) bool {
+	return srtmMinLatitude <= latitude && latitude < srtmMaxLatitude
+}
+
+// normalizeCoordinates wraps longitudes given in the [0, 360] range into [-180, 180) and removes negative zeros
+func normalizeCoordinates(latitude, longitude float64) (float64, float64) {
+	if longitude > 180 {
+		longitude -= 360
+	}
+	// 180 and -180 are the same meridian, but there is only a W180 tile (no E180):
+	if longitude >= 180 {
+		longitude = -180
+	}
+	// -0.0 == 0, but the assignment replaces negative zeros with positive ones:
+	if longitude == 0 {
+		longitude = 0
+	}
+	if latitude == 0 {
+		latitude = 0
+	}
+	return latitude, longitude
+}
+
+// TileName returns the name (for example "N45E013") of the SRTM tile containing the coordinate
+func TileName(latitude, longitude float64) string {
+	name, _, _ := getTileNameAndCoordinates(latitude, longitude)
+	return name
+}
+
+// TileBounds returns the bounding box of a SRTM tile given by name (for example "N45E013" or "N45E013.hgt"),
+// ok is false if the name is not a valid tile name
+func TileBounds(name string) (minLatitude, minLongitude, maxLatitude, maxLongitude float64, ok bool) {
+	name = strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(name, ".zip"), ".hgt"))
+	if len(name) != 7 {
+		return 0, 0, 0, 0, false
+	}
+
+	latitude, err := strconv.Atoi(name[1:3])
+	if err != nil || latitude > 90 {
+		return 0, 0, 0, 0, false
+	}
+	switch name[0] {
+	case 'N':
+		if latitude > 89 {
+			return 0, 0, 0, 0, false
+		}
+	case 'S':
+		if latitude == 0 {
+			return 0, 0, 0, 0, false
+		}
+		latitude = -latitude
+	default:
+		return 0, 0, 0, 0, false
+	}
+
+	longitude, err := strconv.Atoi(name[4:7])
+	if err != nil || longitude > 180 {
+		return 0, 0, 0, 0, false
+	}
+	switch name[3] {
+	case 'E':
+		if longitude > 179 {
+			return 0, 0, 0, 0, false
+		}
+	case 'W':
+		if longitude == 0 {
+			return 0, 0, 0, 0, false
+		}
+		longitude = -longitude
+	default:
+		return 0, 0, 0, 0, false
+	}
+
+	return float64(latitude), float64(longitude), float64(latitude + 1), float64(longitude + 1), true
+}
+
+// TileScheme maps coordinates to the tiles of a DEM and to the samples in them
+type TileScheme interface {
+	// Tile returns the name of the tile covering the (normalized) point and the coordinates of its south-west
+	// corner
+	Tile(latitude, longitude float64) (name string, minLatitude, minLongitude float64)
+	// Position returns the row and column (fractional, row 0 is the northernmost one) of the point in the tile
+	// with the south-west corner in (minLatitude, minLongitude) and squareSize samples per row and column
+	Position(minLatitude, minLongitude float64, squareSize int, latitude, longitude float64) (row, column float64)
+}
+
+// SrtmTileScheme is the default TileScheme: one degree tiles named like "N45E013", with the first and last rows
+// and columns on the tile edges (shared with the neighbouring tiles)
+type SrtmTileScheme struct{}
+
+func (self SrtmTileScheme) Tile(latitude, longitude float64) (string, float64, float64) {
+	return getTileNameAndCoordinates(latitude, longitude)
+}
+
+func (self SrtmTileScheme) Position(minLatitude, minLongitude float64, squareSize int, latitude, longitude float64) (float64, float64) {
+	row := (minLatitude + 1.0 - latitude) * float64(squareSize-1)
+	column := (longitude - minLongitude) * float64(squareSize-1)
+	return row, column
+}
+
+var _ TileScheme = SrtmTileScheme{}
+
+func getTileNameAndCoordinates(latitude, longitude float64) (string, float64, float64) {
+	latitude, longitude = normalizeCoordinates(latitude, longitude)
+
+	northSouth := 'S'
+	if latitude >= 0 {
+		northSouth = 'N'
+	}
+
+	eastWest := 'W'
+	if longitude >= 0 {
+		eastWest = 'E'
+	}
+
+	srtmLatitude := math.Floor(latitude)
+	// The north pole is on the top edge of the N89 tiles (there are no N90 tiles):
+	if srtmLatitude >= 90 {
+		srtmLatitude = 89
+	}
+	srtmLongitude := math.Floor(longitude)
+
+	latPart := int(math.Abs(srtmLatitude))
+	lonPart := int(math.Abs(srtmLongitude))
+
+	srtmFileName := fmt.Sprintf("%s%02d%s%03d", string(northSouth), latPart, string(eastWest), lonPart)
+
+	return srtmFileName, srtmLatitude, srtmLongitude
+}
@@ -0,0 +1,272 @@
+package geoelevations
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultCrawlConcurrency is the default number of listings retrieved in parallel
+const defaultCrawlConcurrency = 4
+
+// defaultCrawlDepth is the default number of subdirectory levels crawled (like SRTM3/Eurasia/)
+const defaultCrawlDepth = 1
+
+// listingsFileName is the file (in the storage) with the directory listings from the last crawl
+const listingsFileName = "listings.json"
+
+// srtmCrawler finds the SRTM files in the directory listings of the server. Listings are retrieved with
+// conditional requests (ETag/Last-Modified), and not parsed again if they didn't change since the last crawl.
+type srtmCrawler struct {
+	ctx    context.Context
+	client *http.Client
+	// requests limits the number of listings retrieved in parallel
+	requests chan struct{}
+	// maxDepth is the number of subdirectory levels crawled below the SRTM1/ and SRTM3/ directories
+	maxDepth int
+	// resolutions to crawl, all if empty
+	resolutions []SrtmResolution
+	// logLevel is the minimum level of the logged messages
+	logLevel LogLevel
+
+	// listingsLock guards listings
+	listingsLock sync.Mutex
+	listings     map[string]*srtmListing
+}
+
+// srtmListing is the parsed directory listing of an url
+type srtmListing struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Tiles        []SrtmUrl `json:"tiles"`
+	Directories  []string  `json:"directories"`
+}
+
+func newSrtmCrawler(ctx context.Context, client *http.Client) *srtmCrawler {
+	return &srtmCrawler{
+		ctx:      ctx,
+		client:   client,
+		requests: make(chan struct{}, defaultCrawlConcurrency),
+		maxDepth: defaultCrawlDepth,
+		listings: map[string]*srtmListing{},
+	}
+}
+
+// loadListings loads the listings saved in the storage by a previous crawl, if any
+func (self *srtmCrawler) loadListings(storage SrtmLocalStorage) {
+	bytes, err := storage.LoadFile(listingsFileName)
+	if err != nil {
+		if !storage.IsNotExists(err) {
+			self.logLevel.logf(LogWarn, "Error loading %s: %s", listingsFileName, err.Error())
+		}
+		return
+	}
+	if err := json.Unmarshal(bytes, &self.listings); err != nil {
+		self.logLevel.logf(LogWarn, "Invalid %s: %s", listingsFileName, err.Error())
+		self.listings = map[string]*srtmListing{}
+	}
+}
+
+func (self *srtmCrawler) saveListings(storage SrtmLocalStorage) error {
+	self.listingsLock.Lock()
+	defer self.listingsLock.Unlock()
+
+	bytes, err := json.Marshal(self.listings)
+	if err != nil {
+		return err
+	}
+	return storage.SaveFile(listingsFileName, bytes)
+}
+
+// loadSrtmDataFromMirrors crawls the first mirror which is online
+func (self *srtmCrawler) loadSrtmDataFromMirrors(mirrors []string) (*SrtmData, error) {
+	var lastErr error
+	for _, mirror := range mirrors {
+		srtmData, err := self.loadSrtmData(mirror)
+		if err == nil {
+			return srtmData, nil
+		}
+		if self.ctx.Err() != nil {
+			return nil, self.ctx.Err()
+		}
+		self.logLevel.logf(LogWarn, "Error crawling %s: %s", mirror, err.Error())
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("No mirrors")
+	}
+	return nil, lastErr
+}
+
+// loadSrtmData crawls a single mirror
+func (self *srtmCrawler) loadSrtmData(mirror string) (*SrtmData, error) {
+	result := new(SrtmData)
+
+	var err error
+	if self.crawlsResolution(SRTM1) {
+		result.Srtm1BaseUrl = mirror + "/SRTM1/"
+		result.Srtm1, err = self.getLinks(result.Srtm1BaseUrl, result.Srtm1BaseUrl, 0)
+		if err != nil {
+			return nil, err
+		}
+		sortSrtmUrls(result.Srtm1)
+	}
+
+	if self.crawlsResolution(SRTM3) {
+		result.Srtm3BaseUrl = mirror + "/SRTM3/"
+		result.Srtm3, err = self.getLinks(result.Srtm3BaseUrl, result.Srtm3BaseUrl, 0)
+		if err != nil {
+			return nil, err
+		}
+		sortSrtmUrls(result.Srtm3)
+	}
+
+	return result, nil
+}
+
+func (self *srtmCrawler) crawlsResolution(resolution SrtmResolution) bool {
+	if len(self.resolutions) == 0 {
+		return true
+	}
+	for _, r := range self.resolutions {
+		if r == resolution {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *srtmCrawler) getLinks(baseUrl, url string, depth int) ([]SrtmUrl, error) {
+	if depth > self.maxDepth {
+		return []SrtmUrl{}, nil
+	}
+
+	listing, err := self.getListing(baseUrl, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subdirectories are crawled in parallel (but only len(self.requests) are retrieved at the same time):
+	links := make([][]SrtmUrl, len(listing.Directories))
+	errs := make([]error, len(listing.Directories))
+	var wg sync.WaitGroup
+	for n, directory := range listing.Directories {
+		wg.Add(1)
+		go func(n int, directory string) {
+			defer wg.Done()
+			links[n], errs[n] = self.getLinks(baseUrl, directory, depth+1)
+		}(n, directory)
+	}
+	wg.Wait()
+
+	result := append(make([]SrtmUrl, 0), listing.Tiles...)
+	for n := range listing.Directories {
+		if errs[n] != nil {
+			return nil, errs[n]
+		}
+		result = append(result, links[n]...)
+	}
+
+	return result, nil
+}
+
+// sortSrtmUrls sorts by name (and url), the order of the crawled urls depends on the order of the responses
+func sortSrtmUrls(srtmUrls []SrtmUrl) {
+	sort.Slice(srtmUrls, func(i, j int) bool {
+		if srtmUrls[i].Name != srtmUrls[j].Name {
+			return srtmUrls[i].Name < srtmUrls[j].Name
+		}
+		return srtmUrls[i].Url < srtmUrls[j].Url
+	})
+}
+
+func (self *srtmCrawler) getListing(baseUrl, url string) (*srtmListing, error) {
+	req, err := http.NewRequestWithContext(self.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	self.listingsLock.Lock()
+	cached := self.listings[url]
+	self.listingsLock.Unlock()
+	if cached != nil {
+		if len(cached.ETag) > 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	select {
+	case self.requests <- struct{}{}:
+		defer func() { <-self.requests }()
+	case <-self.ctx.Done():
+		return nil, self.ctx.Err()
+	}
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		if self.ctx.Err() != nil {
+			return nil, self.ctx.Err()
+		}
+		if redirectErr := asRedirectError(err); redirectErr != nil {
+			return nil, fmt.Errorf("%w: error retrieving %s", redirectErr, url)
+		}
+		return nil, fmt.Errorf("%w: error retrieving %s: %s", ErrOffline, url, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("%w: %s returned %s", ErrOffline, url, resp.Status)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		self.logLevel.logf(LogDebug, "> %s not modified\n", url)
+		return cached, nil
+	}
+
+	listing := &srtmListing{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Tiles:        []SrtmUrl{},
+		Directories:  []string{},
+	}
+
+	var body io.Reader = resp.Body
+	if isGzipEncoded(resp) {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid gzip encoded listing %s: %s", url, err.Error()))
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	urls := getLinksFromHtmlDocument(body)
+	for _, tmpUrl := range urls {
+		urlLowercase := strings.ToLower(tmpUrl)
+		if extension := compressedExtension(urlLowercase); len(extension) > 0 && strings.HasSuffix(urlLowercase, ".hgt"+extension) {
+			parts := strings.Split(tmpUrl, "/")
+			name := parts[len(parts)-1]
+			name = name[:len(name)-len(".hgt"+extension)]
+			u := strings.Replace(fmt.Sprintf("%s/%s", url, tmpUrl), baseUrl, "", 1)
+			listing.Tiles = append(listing.Tiles, SrtmUrl{Name: name, Url: u})
+			self.logLevel.logf(LogDebug, "> %s/%s -> %s\n", url, tmpUrl, tmpUrl)
+		} else if len(urlLowercase) > 0 && urlLowercase[0] != '/' && !strings.HasPrefix(urlLowercase, "http") && !strings.HasSuffix(urlLowercase, ".jpg") {
+			listing.Directories = append(listing.Directories, fmt.Sprintf("%s/%s", url, tmpUrl))
+			self.logLevel.logf(LogDebug, "> %s\n", tmpUrl)
+		}
+	}
+
+	self.listingsLock.Lock()
+	self.listings[url] = listing
+	self.listingsLock.Unlock()
+	return listing, nil
+}
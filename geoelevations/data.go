@@ -1,15 +1,41 @@
 package geoelevations
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strings"
 )
 
+type SrtmResolution int
+
+const (
+	// SRTM1 tiles have one arc-second samples (3601x3601)
+	SRTM1 SrtmResolution = 1
+	// SRTM3 tiles have three arc-second samples (1201x1201)
+	SRTM3 SrtmResolution = 3
+)
+
+// SquareSize is the expected number of rows (and columns) of a tile with this resolution, 0 if unknown
+func (self SrtmResolution) SquareSize() int {
+	switch self {
+	case SRTM1:
+		return 3601
+	case SRTM3:
+		return 1201
+	}
+	return 0
+}
+
 type SrtmUrl struct {
 	// FileName without extension
 	Name string `json:"n"`
 	Url  string `json:"u"`
+	// Sha256 (hex) of the file, optional
+	Sha256 string `json:"s,omitempty"`
 
 	baseUrl string `json:"-"`
 }
@@ -19,28 +45,58 @@ type SrtmData struct {
 	Srtm1BaseUrl string    `json:"srtm1_base_url"`
 	Srtm1        []SrtmUrl `json:"srtm1"`
 	Srtm3BaseUrl string    `json:"srtm3_base_url"`
-	Srtm3        []SrtmUrl `json:"srtm2"`
+	// The "srtm2" key is a historical typo, kept so that existing urls.json files can still be read
+	Srtm3 []SrtmUrl `json:"srtm2"`
 }
 
-func newSrtmData(client *http.Client, storage SrtmLocalStorage) (*SrtmData, error) {
-	fn := "urls.json"
+// WriteJSON serializes the index (in the same format as the urls.json file in the storage)
+func (self *SrtmData) WriteJSON(writer io.Writer) error {
+	return json.NewEncoder(writer).Encode(self)
+}
 
-	bytes, err := storage.LoadFile(fn)
+// ReadSrtmData deserializes an index written with WriteJSON (or a urls.json file)
+func ReadSrtmData(reader io.Reader) (*SrtmData, error) {
+	srtmData := new(SrtmData)
+	if err := json.NewDecoder(reader).Decode(srtmData); err != nil {
+		return nil, err
+	}
+	return srtmData, nil
+}
+
+// String describes the index, for debugging
+func (self *SrtmData) String() string {
+	return fmt.Sprintf("SRTM1: %d tiles at %s, SRTM3: %d tiles at %s", len(self.Srtm1), self.Srtm1BaseUrl, len(self.Srtm3), self.Srtm3BaseUrl)
+}
+
+func newSrtmData(client *http.Client, storage SrtmLocalStorage, mirrors ...string) (*SrtmData, error) {
+	return newSrtmDataWithCrawler(newSrtmCrawler(context.Background(), client), storage, mirrors...)
+}
+
+// srtmDataFileName is the file (in the storage) with the SRTM files index
+const srtmDataFileName = "urls.json"
+
+// newSrtmDataWithCrawler loads the SRTM files index from the storage, crawling the mirrors if it is not there yet
+func newSrtmDataWithCrawler(crawler *srtmCrawler, storage SrtmLocalStorage, mirrors ...string) (*SrtmData, error) {
+	if len(mirrors) == 0 {
+		mirrors = DefaultMirrors
+	}
+
+	bytes, err := storage.LoadFile(srtmDataFileName)
 	if err != nil {
 		if storage.IsNotExists(err) {
-			srtmData, err := LoadSrtmData(client)
+			crawler.loadListings(storage)
+			srtmData, err := crawler.loadSrtmDataFromMirrors(mirrors)
 			if err != nil {
 				return nil, err
 			}
-			b, err := json.Marshal(srtmData)
-			if err != nil {
+			if err := crawler.saveListings(storage); err != nil {
 				return nil, err
 			}
-			bytes = b
-
-			if err := storage.SaveFile(fn, bytes); err != nil {
+			b, err := saveSrtmData(storage, srtmData)
+			if err != nil {
 				return nil, err
 			}
+			bytes = b
 		} else {
 			return nil, err
 		}
@@ -54,13 +110,68 @@ func newSrtmData(client *http.Client, storage SrtmLocalStorage) (*SrtmData, erro
 	return srtmData, nil
 }
 
+func saveSrtmData(storage SrtmLocalStorage, srtmData *SrtmData) ([]byte, error) {
+	bytes, err := json.Marshal(srtmData)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.SaveFile(srtmDataFileName, bytes); err != nil {
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// AvailableTiles returns the sorted names of all the tiles (of any resolution) with a known URL
+func (self *SrtmData) AvailableTiles() []string {
+	names := map[string]bool{}
+	for _, srtmUrls := range [][]SrtmUrl{self.Srtm1, self.Srtm3} {
+		for _, srtmUrl := range srtmUrls {
+			names[srtmUrl.Name] = true
+		}
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// DefaultResolutionPreference is the order in which resolutions are looked up if a tile is available in more than
+// one, see WithResolutionPreference
+var DefaultResolutionPreference = []SrtmResolution{SRTM1, SRTM3}
+
+// GetBestSrtmUrl returns the URL of the tile in the first resolution of DefaultResolutionPreference with it
 func (self *SrtmData) GetBestSrtmUrl(fileName string) (string, *SrtmUrl) {
-	baseUrl, srtm3Url := self.GetSrtm3Url(fileName)
-	if srtm3Url != nil {
-		return baseUrl, srtm3Url
+	baseUrl, srtmUrl, _ := self.getBestSrtmUrl(fileName, nil)
+	return baseUrl, srtmUrl
+}
+
+// getBestSrtmUrl returns the URL of the tile in the first resolution of preference (or DefaultResolutionPreference,
+// if empty) with it
+func (self *SrtmData) getBestSrtmUrl(fileName string, preference []SrtmResolution) (string, *SrtmUrl, SrtmResolution) {
+	if len(preference) == 0 {
+		preference = DefaultResolutionPreference
 	}
+	for _, resolution := range preference {
+		if baseUrl, srtmUrl := self.GetSrtmUrl(fileName, resolution); srtmUrl != nil {
+			return baseUrl, srtmUrl, resolution
+		}
+	}
+	return "", nil, 0
+}
 
-	return self.GetSrtm1Url(fileName)
+// GetSrtmUrl returns the base URL and the URL of the tile with the resolution, the URL is nil if there is no such
+// tile with this resolution
+func (self *SrtmData) GetSrtmUrl(fileName string, resolution SrtmResolution) (string, *SrtmUrl) {
+	switch resolution {
+	case SRTM1:
+		return self.GetSrtm1Url(fileName)
+	case SRTM3:
+		return self.GetSrtm3Url(fileName)
+	}
+	return "", nil
 }
 
 func (self *SrtmData) GetSrtm1Url(fileName string) (string, *SrtmUrl) {
@@ -0,0 +1,121 @@
+package geoelevations
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Decompressor extracts the .hgt contents from a compressed tile file
+type Decompressor interface {
+	Decompress(compressed []byte) ([]byte, error)
+}
+
+// DecompressorFunc is a function implementing Decompressor
+type DecompressorFunc func(compressed []byte) ([]byte, error)
+
+func (self DecompressorFunc) Decompress(compressed []byte) ([]byte, error) {
+	return self(compressed)
+}
+
+// ZipDecompressor extracts the .hgt file from a .zip archive, see UnzipHGT
+type ZipDecompressor struct{}
+
+func (self ZipDecompressor) Decompress(compressed []byte) ([]byte, error) {
+	return unzipBytes(compressed)
+}
+
+// GzipDecompressor decompresses a gzipped .hgt file
+type GzipDecompressor struct{}
+
+func (self GzipDecompressor) Decompress(compressed []byte) ([]byte, error) {
+	return ungzipReader(bytes.NewReader(compressed), gzipSizeHint(bytes.NewReader(compressed), int64(len(compressed))))
+}
+
+var (
+	decompressorsLock sync.RWMutex
+	// decompressors by (lowercase) file extension
+	decompressors = map[string]Decompressor{
+		".zip": ZipDecompressor{},
+		".gz":  GzipDecompressor{},
+	}
+)
+
+// RegisterDecompressor sets the decompressor of tile files with the extension (for example ".bz2" for
+// "N45E013.hgt.bz2"). Tiles with this extension are then found in the SRTM directory listings, downloaded and
+// cached like .hgt.zip tiles.
+func RegisterDecompressor(extension string, decompressor Decompressor) {
+	decompressorsLock.Lock()
+	defer decompressorsLock.Unlock()
+
+	decompressors[strings.ToLower(extension)] = decompressor
+}
+
+func getDecompressor(extension string) Decompressor {
+	decompressorsLock.RLock()
+	defer decompressorsLock.RUnlock()
+
+	return decompressors[strings.ToLower(extension)]
+}
+
+// compressedExtensions returns the extensions with a decompressor, .zip and .gz first
+func compressedExtensions() []string {
+	decompressorsLock.RLock()
+	defer decompressorsLock.RUnlock()
+
+	result := []string{".zip", ".gz"}
+	others := []string{}
+	for extension := range decompressors {
+		if extension != ".zip" && extension != ".gz" {
+			others = append(others, extension)
+		}
+	}
+	sort.Strings(others)
+	return append(result, others...)
+}
+
+// compressedExtension returns the extension (with a decompressor) of the file name or URL, or "" if none
+func compressedExtension(fileName string) string {
+	lowercase := strings.ToLower(fileName)
+	for _, extension := range compressedExtensions() {
+		if strings.HasSuffix(lowercase, extension) && getDecompressor(extension) != nil {
+			return extension
+		}
+	}
+	return ""
+}
+
+// isCompressedTileBytes checks if the file (retrieved from fileName) looks like a compressed tile
+func isCompressedTileBytes(fileName string, byts []byte) bool {
+	if isZipBytes(byts) || isGzipBytes(byts) {
+		return true
+	}
+	// Other formats can't be recognized by the contents:
+	extension := compressedExtension(fileName)
+	return extension != "" && extension != ".zip" && extension != ".gz"
+}
+
+// decompressTile returns the .hgt contents of a compressed tile. Zipped and gzipped files are recognized by their
+// contents (some servers use the wrong extension), other formats by the extension of fileName.
+func decompressTile(fileName string, byts []byte) ([]byte, error) {
+	extension := compressedExtension(fileName)
+	if isGzipBytes(byts) {
+		extension = ".gz"
+	} else if isZipBytes(byts) {
+		extension = ".zip"
+	}
+
+	// Zip and gzip files are always recognizable, anything else is an error page or a truncated file:
+	if (extension == ".zip" || extension == ".gz") && !isZipBytes(byts) && !isGzipBytes(byts) {
+		return nil, errors.New(fmt.Sprintf("%s is not a %s file, starts with %s", fileName, extension, bytesPrefix(byts)))
+	}
+
+	decompressor := getDecompressor(extension)
+	if decompressor == nil {
+		return nil, errors.New(fmt.Sprintf("No decompressor for %s", fileName))
+	}
+	return decompressor.Decompress(byts)
+}
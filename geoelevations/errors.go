@@ -0,0 +1,55 @@
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrTileNotAvailable is returned when a tile can't be retrieved from the server
+	ErrTileNotAvailable = errors.New("Tile not available")
+	// ErrCorruptTile is returned for tiles which can't be decompressed or have an invalid size
+	ErrCorruptTile = errors.New("Corrupt tile")
+	// ErrInvalidCoordinate is returned for coordinates out of range
+	ErrInvalidCoordinate = errors.New("Invalid coordinate")
+	// ErrNoCoverage is returned for points poleward of the SRTM coverage (north of 60°N or south of 56°S) without a
+	// tile in the index
+	ErrNoCoverage = errors.New("No SRTM coverage")
+	// ErrOffline is returned when the server can't be reached
+	ErrOffline = errors.New("Offline")
+	// ErrTooManyRedirects is returned when a server redirects more times than allowed by WithMaxRedirects
+	ErrTooManyRedirects = errors.New("Too many redirects")
+	// ErrRedirectToOtherHost is returned when a server redirects to another host and WithSameHostRedirects is used
+	ErrRedirectToOtherHost = errors.New("Redirect to other host")
+)
+
+// ElevationsError is returned by the batch functions (like GetElevations) when some points failed
+type ElevationsError struct {
+	// Errors by tile name (or coordinates, for invalid coordinates)
+	Errors map[string]error
+}
+
+func (self *ElevationsError) Error() string {
+	names := make([]string, 0, len(self.Errors))
+	for name := range self.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for n, name := range names {
+		messages[n] = fmt.Sprintf("%s: %s", name, self.Errors[name].Error())
+	}
+	return fmt.Sprintf("Error in %d tiles: %s", len(names), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of all the tiles, so that errors.Is and errors.As check them all
+func (self *ElevationsError) Unwrap() []error {
+	result := make([]error, 0, len(self.Errors))
+	for _, err := range self.Errors {
+		result = append(result, err)
+	}
+	return result
+}
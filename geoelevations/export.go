@@ -0,0 +1,46 @@
+package geoelevations
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ExportASCIIGrid writes the elevations of the bounding box (with resolution samples per degree) as an Arc/Info
+// ASCII grid, unknown elevations are written as the void value (-32768)
+func (self *Srtm) ExportASCIIGrid(w io.Writer, minLat, minLon, maxLat, maxLon float64, resolution int) error {
+	grid, err := self.GetGrid(minLat, minLon, maxLat, maxLon, resolution)
+	if err != nil {
+		return err
+	}
+	return grid.WriteASCIIGrid(w)
+}
+
+// WriteASCIIGrid writes the grid in the Arc/Info ASCII grid format
+func (self *ElevationGrid) WriteASCIIGrid(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "ncols %d\n", self.Columns)
+	fmt.Fprintf(writer, "nrows %d\n", self.Rows)
+	fmt.Fprintf(writer, "xllcorner %s\n", strconv.FormatFloat(self.MinLongitude, 'f', -1, 64))
+	fmt.Fprintf(writer, "yllcorner %s\n", strconv.FormatFloat(self.MinLatitude, 'f', -1, 64))
+	fmt.Fprintf(writer, "cellsize %s\n", strconv.FormatFloat(self.CellSize, 'f', -1, 64))
+	fmt.Fprintf(writer, "NODATA_value %d\n", voidValue)
+
+	for row := 0; row < self.Rows; row++ {
+		for column := 0; column < self.Columns; column++ {
+			if column > 0 {
+				_ = writer.WriteByte(' ')
+			}
+			elevation := self.Get(row, column)
+			if math.IsNaN(elevation) {
+				elevation = voidValue
+			}
+			_, _ = writer.WriteString(strconv.FormatFloat(elevation, 'f', -1, 64))
+		}
+		_ = writer.WriteByte('\n')
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,62 @@
+package geoelevations
+
+import (
+	"math"
+)
+
+const earthRadius = 6371000.0
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func toDegrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+// haversineDistance returns the great-circle distance (in meters) between two points
+func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// intermediatePoint returns the point at fraction (0..1) of the great-circle path between two points
+func intermediatePoint(lat1, lon1, lat2, lon2, fraction float64) (float64, float64) {
+	phi1, lambda1 := toRadians(lat1), toRadians(lon1)
+	phi2, lambda2 := toRadians(lat2), toRadians(lon2)
+
+	// Angular distance:
+	delta := haversineDistance(lat1, lon1, lat2, lon2) / earthRadius
+	if delta == 0 {
+		return lat1, lon1
+	}
+
+	a := math.Sin((1-fraction)*delta) / math.Sin(delta)
+	b := math.Sin(fraction*delta) / math.Sin(delta)
+	x := a*math.Cos(phi1)*math.Cos(lambda1) + b*math.Cos(phi2)*math.Cos(lambda2)
+	y := a*math.Cos(phi1)*math.Sin(lambda1) + b*math.Cos(phi2)*math.Sin(lambda2)
+	z := a*math.Sin(phi1) + b*math.Sin(phi2)
+
+	return toDegrees(math.Atan2(z, math.Sqrt(x*x+y*y))), toDegrees(math.Atan2(y, x))
+}
+
+// Densify returns the points along the great-circle path from one point to the other, evenly spaced and no more
+// than intervalMeters apart. Both endpoints are included, so segments shorter than the interval (or an invalid
+// interval) return just the endpoints.
+func Densify(from, to Point, intervalMeters float64) []Point {
+	distance := haversineDistance(from.Lat, from.Lon, to.Lat, to.Lon)
+	segments := 1
+	if intervalMeters > 0 && distance > intervalMeters {
+		segments = int(math.Ceil(distance / intervalMeters))
+	}
+
+	result := make([]Point, 0, segments+1)
+	result = append(result, from)
+	for n := 1; n < segments; n++ {
+		latitude, longitude := intermediatePoint(from.Lat, from.Lon, to.Lat, to.Lon, float64(n)/float64(segments))
+		result = append(result, Point{Lat: latitude, Lon: longitude})
+	}
+	return append(result, to)
+}
@@ -0,0 +1,244 @@
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ElevationGrid are elevations sampled at regular intervals in a bounding box
+type ElevationGrid struct {
+	// MinLatitude and MinLongitude are the south-west corner of the grid
+	MinLatitude, MinLongitude float64
+	// CellSize is the size (in degrees) of a grid cell
+	CellSize      float64
+	Rows, Columns int
+	// Elevations (NaN if unknown) of the cell centers, row by row from the north-west corner
+	Elevations []float64
+}
+
+// Get returns the elevation of a cell, row 0 is the northernmost one
+func (self *ElevationGrid) Get(row, column int) float64 {
+	return self.Elevations[row*self.Columns+column]
+}
+
+// CellCenter returns the coordinates of the center of a cell
+func (self *ElevationGrid) CellCenter(row, column int) (float64, float64) {
+	latitude := self.MinLatitude + (float64(self.Rows-row)-0.5)*self.CellSize
+	longitude := self.MinLongitude + (float64(column)+0.5)*self.CellSize
+	return latitude, longitude
+}
+
+// GetGrid samples the elevations in the bounding box, with resolution samples per degree. The grid starts at the
+// south-west corner of the bounding box, the last row and column may extend a bit beyond it.
+func (self *Srtm) GetGrid(minLat, minLon, maxLat, maxLon float64, resolution int) (*ElevationGrid, error) {
+	grid := &ElevationGrid{}
+	err := self.sampleGrid(minLat, minLon, maxLat, maxLon, resolution, grid, func(cells int) {
+		grid.Elevations = make([]float64, cells)
+	}, func(i int, elevation float64) {
+		grid.Elevations[i] = elevation
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grid, nil
+}
+
+// ElevationGridFloat32 is like ElevationGrid, with float32 elevations (half the memory, for example to upload them to
+// the GPU)
+type ElevationGridFloat32 struct {
+	// MinLatitude and MinLongitude are the south-west corner of the grid
+	MinLatitude, MinLongitude float64
+	// CellSize is the size (in degrees) of a grid cell
+	CellSize      float64
+	Rows, Columns int
+	// Elevations (NaN if unknown) of the cell centers, row by row from the north-west corner
+	Elevations []float32
+}
+
+// Get returns the elevation of a cell, row 0 is the northernmost one
+func (self *ElevationGridFloat32) Get(row, column int) float32 {
+	return self.Elevations[row*self.Columns+column]
+}
+
+// CellCenter returns the coordinates of the center of a cell
+func (self *ElevationGridFloat32) CellCenter(row, column int) (float64, float64) {
+	geometry := ElevationGrid{MinLatitude: self.MinLatitude, MinLongitude: self.MinLongitude, CellSize: self.CellSize, Rows: self.Rows, Columns: self.Columns}
+	return geometry.CellCenter(row, column)
+}
+
+// GetGridFloat32 is like GetGrid, with float32 elevations
+func (self *Srtm) GetGridFloat32(minLat, minLon, maxLat, maxLon float64, resolution int) (*ElevationGridFloat32, error) {
+	grid := &ElevationGridFloat32{}
+	geometry := &ElevationGrid{}
+	err := self.sampleGrid(minLat, minLon, maxLat, maxLon, resolution, geometry, func(cells int) {
+		grid.Elevations = make([]float32, cells)
+	}, func(i int, elevation float64) {
+		grid.Elevations[i] = float32(elevation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	grid.MinLatitude, grid.MinLongitude, grid.CellSize = geometry.MinLatitude, geometry.MinLongitude, geometry.CellSize
+	grid.Rows, grid.Columns = geometry.Rows, geometry.Columns
+	return grid, nil
+}
+
+// sampleGrid sets the size of the grid (but not the elevations), calls allocate with the number of cells, and then
+// set with the index and elevation of every cell
+func (self *Srtm) sampleGrid(minLat, minLon, maxLat, maxLon float64, resolution int, grid *ElevationGrid, allocate func(cells int), set func(i int, elevation float64)) error {
+	if err := validateRegion(minLat, minLon, maxLat, maxLon); err != nil {
+		return err
+	}
+	if resolution <= 0 {
+		return errors.New(fmt.Sprintf("Invalid resolution: %d", resolution))
+	}
+
+	grid.MinLatitude = minLat
+	grid.MinLongitude = minLon
+	grid.CellSize = 1 / float64(resolution)
+	grid.Rows = gridCells(maxLat-minLat, resolution)
+	grid.Columns = gridCells(maxLon-minLon, resolution)
+	allocate(grid.Rows * grid.Columns)
+	for row := 0; row < grid.Rows; row++ {
+		for column := 0; column < grid.Columns; column++ {
+			latitude, longitude := grid.CellCenter(row, column)
+			// Cells extending beyond the north pole or the antimeridian:
+			if latitude > 90 || longitude > 360 {
+				set(row*grid.Columns+column, math.NaN())
+				continue
+			}
+			elevation, err := self.getSampledElevation(latitude, longitude)
+			if err != nil {
+				return err
+			}
+			set(row*grid.Columns+column, elevation)
+		}
+	}
+
+	return nil
+}
+
+// gridCells is the number of cells (at least one) needed to cover degrees
+func gridCells(degrees float64, resolution int) int {
+	// Without the small tolerance, floating point errors could add a cell:
+	cells := int(math.Ceil(degrees*float64(resolution) - 1e-9))
+	if cells < 1 {
+		return 1
+	}
+	return cells
+}
+
+// gradient returns the elevation change (in meters per meter) to the east and to the north of a cell, from the
+// 3x3 neighbourhood of the cell (Horn's method). Unknown neighbours are replaced by the cell itself and cells at
+// the edges of the grid reuse the edge cells, the gradient of an unknown cell is 0.
+func (self *ElevationGrid) gradient(row, column int) (float64, float64) {
+	center := self.Get(row, column)
+	if math.IsNaN(center) {
+		return 0, 0
+	}
+	z := func(rowOffset, columnOffset int) float64 {
+		elevation := self.Get(clampInt(row+rowOffset, 0, self.Rows-1), clampInt(column+columnOffset, 0, self.Columns-1))
+		if math.IsNaN(elevation) {
+			return center
+		}
+		return elevation
+	}
+
+	latitude, _ := self.CellCenter(row, column)
+	metersPerDegree := earthRadius * math.Pi / 180
+	dx := self.CellSize * metersPerDegree * math.Cos(toRadians(latitude))
+	dy := self.CellSize * metersPerDegree
+
+	east := ((z(-1, 1) + 2*z(0, 1) + z(1, 1)) - (z(-1, -1) + 2*z(0, -1) + z(1, -1))) / (8 * dx)
+	north := ((z(-1, -1) + 2*z(-1, 0) + z(-1, 1)) - (z(1, -1) + 2*z(1, 0) + z(1, 1))) / (8 * dy)
+	return east, north
+}
+
+// GetTileGrid loads the tile covering the coordinate and returns its raw samples (row by row from the north-west
+// corner, voids are -32768) and the number of samples per row and column
+func (self *Srtm) GetTileGrid(latitude, longitude float64) ([]int16, int, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return nil, 0, err
+	}
+	latitude, longitude = normalizeCoordinates(latitude, longitude)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, err := self.loadTile(latitude, longitude)
+	if err != nil {
+		return nil, 0, err
+	}
+	if srtmFile == nil {
+		name, _, _ := self.getSrtmFileNameAndCoordinates(latitude, longitude)
+		return nil, 0, fmt.Errorf("%w: %s", ErrTileNotAvailable, name)
+	}
+
+	samples := make([]int16, srtmFile.squareSize*srtmFile.squareSize)
+	for i := range samples {
+		samples[i] = srtmFile.getSample(i)
+	}
+	return samples, srtmFile.squareSize, nil
+}
+
+// ForEachSample loads the tile covering the coordinate and calls fn with the coordinates and the raw value (voids
+// are -32768) of each of its samples, row by row from the north-west corner. fn must not call other Srtm methods.
+func (self *Srtm) ForEachSample(latitude, longitude float64, fn func(sampleLat, sampleLon float64, elevation int16)) error {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return err
+	}
+	latitude, longitude = normalizeCoordinates(latitude, longitude)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, err := self.loadTile(latitude, longitude)
+	if err != nil {
+		return err
+	}
+	if srtmFile == nil {
+		name, _, _ := self.getSrtmFileNameAndCoordinates(latitude, longitude)
+		return fmt.Errorf("%w: %s", ErrTileNotAvailable, name)
+	}
+
+	last := srtmFile.squareSize - 1
+	for row := 0; row <= last; row++ {
+		sampleLat := srtmFile.latitude + 1 - float64(row)/float64(last)
+		for column := 0; column <= last; column++ {
+			sampleLon := srtmFile.longitude + float64(column)/float64(last)
+			fn(sampleLat, sampleLon, srtmFile.getSample(row*srtmFile.squareSize+column))
+		}
+	}
+	return nil
+}
+
+// TileVoidFraction loads the tile covering the coordinate and returns the fraction (0 to 1) of its samples which
+// are voids (-32768, or invalid values above 9000)
+func (self *Srtm) TileVoidFraction(latitude, longitude float64) (float64, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return math.NaN(), err
+	}
+	latitude, longitude = normalizeCoordinates(latitude, longitude)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, err := self.loadTile(latitude, longitude)
+	if err != nil {
+		return math.NaN(), err
+	}
+	if srtmFile == nil {
+		name, _, _ := self.getSrtmFileNameAndCoordinates(latitude, longitude)
+		return math.NaN(), fmt.Errorf("%w: %s", ErrTileNotAvailable, name)
+	}
+
+	samples := srtmFile.squareSize * srtmFile.squareSize
+	voids := 0
+	for i := 0; i < samples; i++ {
+		if math.IsNaN(srtmFile.decodeSample(srtmFile.contents, i)) {
+			voids++
+		}
+	}
+	return float64(voids) / float64(samples), nil
+}
@@ -0,0 +1,128 @@
+package geoelevations
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport waits for the limiter before each request
+type rateLimitedTransport struct {
+	limiter   *rate.Limiter
+	transport http.RoundTripper
+}
+
+// rateLimitWaitedKey is set (to true) in the context of requests which already waited for the limiter, see
+// Srtm.readRangeRequests
+type rateLimitWaitedKey struct{}
+
+func (self *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if waited, _ := req.Context().Value(rateLimitWaitedKey{}).(bool); !waited {
+		if err := self.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := self.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
+
+var _ http.RoundTripper = new(rateLimitedTransport)
+
+// defaultMaxRedirects is the limit of the net/http default client
+const defaultMaxRedirects = 10
+
+// redirectPolicy is the http.Client CheckRedirect configured with WithMaxRedirects and WithSameHostRedirects
+type redirectPolicy struct {
+	maxRedirects int
+	sameHost     bool
+}
+
+func (self *redirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > self.maxRedirects {
+		return fmt.Errorf("%w: more than %d redirects to %s", ErrTooManyRedirects, self.maxRedirects, req.URL.String())
+	}
+	if self.sameHost && !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return fmt.Errorf("%w: %s redirected to %s", ErrRedirectToOtherHost, via[0].URL.String(), req.URL.String())
+	}
+	return nil
+}
+
+// asRedirectError returns the redirect policy error (ErrTooManyRedirects or ErrRedirectToOtherHost) which made the
+// request fail, or nil
+func asRedirectError(err error) error {
+	if errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrRedirectToOtherHost) {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	return nil
+}
+
+// retryAfterError is an error response with a Retry-After header
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (self *retryAfterError) Error() string {
+	return self.err.Error()
+}
+
+func (self *retryAfterError) Unwrap() error {
+	return self.err
+}
+
+// parseRetryAfter parses a Retry-After header value (seconds or a HTTP date)
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// readResponseBody reads the whole body in a buffer presized from the Content-Length (if known and not over the
+// size of a tile), instead of growing it while reading
+func readResponseBody(response *http.Response) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if response.ContentLength > 0 && response.ContentLength <= maxTileBytes {
+		buf.Grow(int(response.ContentLength) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(response.Body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isGzipEncoded checks if the body is still gzip encoded. The transport decodes it only if it asked for gzip itself,
+// but some servers send it anyway (or with a custom transport).
+func isGzipEncoded(response *http.Response) bool {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	return !response.Uncompressed && (encoding == "gzip" || encoding == "x-gzip")
+}
@@ -0,0 +1,276 @@
+package geoelevations
+
+import (
+	"context"
+	"math"
+	"net/http"
+)
+
+type InterpolationMode int
+
+const (
+	// InterpolationNearest returns the sample of the cell containing the point. Voids are filled with the
+	// average of the row and column estimates (see interpolateVoid).
+	InterpolationNearest InterpolationMode = iota
+	// InterpolationBilinear interpolates between the four samples around the point.
+	InterpolationBilinear
+	// InterpolationInverseDistance is like InterpolationNearest, but the four samples used to fill voids are
+	// weighted by the inverse of their distance, so that a close valid sample dominates a far one.
+	InterpolationInverseDistance
+	// InterpolationBicubic interpolates (Catmull-Rom) between the 4x4 samples around the point, for smooth
+	// surfaces without kinks. Near the tile edges and voids (where the 16 samples aren't available), falls back
+	// to InterpolationBilinear.
+	InterpolationBicubic
+)
+
+// interpolate applies the interpolation mode to the elevation found for the point (voids are filled as configured
+// with WithInterpolation), must be called with the lock held
+func (self *Srtm) interpolate(client *http.Client, srtmFile *SrtmFile, latitude, longitude, elevation float64, mode InterpolationMode) ElevationDetails {
+	if mode == InterpolationBicubic {
+		if bicubic := interpolateBicubic(srtmFile, latitude, longitude); !math.IsNaN(bicubic) {
+			return ElevationDetails{Elevation: bicubic}
+		}
+		metricsOrNoop(self.metrics).InterpolationFallback(srtmFile.name, InterpolationBicubic, InterpolationBilinear)
+	}
+	if mode == InterpolationBilinear || mode == InterpolationBicubic {
+		if bilinear := self.interpolateBilinear(client, srtmFile, latitude, longitude); !math.IsNaN(bilinear) {
+			return ElevationDetails{Elevation: bilinear}
+		}
+		metricsOrNoop(self.metrics).InterpolationFallback(srtmFile.name, InterpolationBilinear, InterpolationNearest)
+	}
+	if math.IsNaN(elevation) {
+		row, column := srtmFile.getRowAndColumn(latitude, longitude)
+		elevation, distance := self.interpolateVoid(client, srtmFile, row, column, mode)
+		return ElevationDetails{Elevation: elevation, Interpolated: !math.IsNaN(elevation), MaxScanDistance: distance}
+	}
+	return ElevationDetails{Elevation: elevation}
+}
+
+// interpolateBilinear interpolates between the four samples around the point (voids are ignored) or NaN if all
+// of them are voids
+func (self *Srtm) interpolateBilinear(client *http.Client, srtmFile *SrtmFile, latitude, longitude float64) float64 {
+	last := srtmFile.squareSize - 1
+	rowFloat, columnFloat := srtmFile.getPosition(latitude, longitude)
+
+	// On the bottom/right edge, interpolate in the last cell (instead of the neighbouring tile):
+	row := clampInt(int(math.Floor(rowFloat)), 0, last-1)
+	column := clampInt(int(math.Floor(columnFloat)), 0, last-1)
+	rowFraction := rowFloat - float64(row)
+	columnFraction := columnFloat - float64(column)
+
+	var sum, weights float64
+	for _, corner := range [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}} {
+		weight := math.Abs(1-float64(corner[0])-rowFraction) * math.Abs(1-float64(corner[1])-columnFraction)
+		if weight == 0 {
+			continue
+		}
+		elevation := self.getSample(client, srtmFile, row+corner[0], column+corner[1])
+		if math.IsNaN(elevation) {
+			continue
+		}
+		sum += weight * elevation
+		weights += weight
+	}
+
+	if weights == 0 {
+		return math.NaN()
+	}
+	return sum / weights
+}
+
+// fillVoid replaces the unknown elevation of a point in a void with the fill value, if configured
+func (self *Srtm) fillVoid(srtmFile *SrtmFile, details ElevationDetails) ElevationDetails {
+	if self.voidFillTileMean {
+		if mean := srtmFile.meanElevation(); !math.IsNaN(mean) {
+			return ElevationDetails{Elevation: mean, Filled: true}
+		}
+	}
+	if self.voidFill != nil {
+		return ElevationDetails{Elevation: *self.voidFill, Filled: true}
+	}
+	return details
+}
+
+// meanElevation returns the mean of the valid samples of the (loaded) tile, NaN if none
+func (self *SrtmFile) meanElevation() float64 {
+	if !math.IsNaN(self.mean) || len(self.contents) == 0 || self.squareSize <= 0 {
+		return self.mean
+	}
+
+	var sum float64
+	var count int
+	for i := 0; i < self.squareSize*self.squareSize; i++ {
+		if elevation := self.decodeSample(self.contents, i); !math.IsNaN(elevation) {
+			sum += elevation
+			count++
+		}
+	}
+	if count > 0 {
+		self.mean = sum / float64(count)
+	}
+	return self.mean
+}
+
+// interpolateBicubic interpolates between the 4x4 samples around the point, or NaN if some of them are voids or out
+// of the tile
+func interpolateBicubic(srtmFile *SrtmFile, latitude, longitude float64) float64 {
+	last := srtmFile.squareSize - 1
+	rowFloat, columnFloat := srtmFile.getPosition(latitude, longitude)
+	row := clampInt(int(math.Floor(rowFloat)), 0, last-1)
+	column := clampInt(int(math.Floor(columnFloat)), 0, last-1)
+	if row < 1 || column < 1 || row+2 > last || column+2 > last {
+		return math.NaN()
+	}
+
+	var rows [4]float64
+	for i := range rows {
+		var samples [4]float64
+		for j := range samples {
+			samples[j] = srtmFile.getElevationFromRowAndColumn(row-1+i, column-1+j)
+			if math.IsNaN(samples[j]) {
+				return math.NaN()
+			}
+		}
+		rows[i] = cubicInterpolation(samples, columnFloat-float64(column))
+	}
+	return cubicInterpolation(rows, rowFloat-float64(row))
+}
+
+// cubicInterpolation is the Catmull-Rom spline through the four samples, in x (0 to 1) between the second and third
+func cubicInterpolation(p [4]float64, x float64) float64 {
+	return p[1] + 0.5*x*(p[2]-p[0]+x*(2*p[0]-5*p[1]+4*p[2]-p[3]+x*(3*(p[1]-p[2])+p[3]-p[0])))
+}
+
+// interpolateVoid estimates the elevation of a void sample from the nearest valid samples in the same row and
+// column (no more than voidSearchLimit cells away, if set). The row and column estimates are linear interpolations
+// of the samples found on both sides, and the result is their average (with InterpolationInverseDistance, the
+// average of the four samples weighted by the inverse of their distance). Near the tile edge, the search continues
+// into the neighbouring tiles. Returns the estimate and the distance of the farthest sample used. Must be called
+// with the lock held.
+func (self *Srtm) interpolateVoid(client *http.Client, srtmFile *SrtmFile, row, column int, mode InterpolationMode) (float64, int) {
+	maxDistance := srtmFile.squareSize
+	if self.voidSearchLimit > 0 {
+		maxDistance = self.voidSearchLimit
+	}
+
+	west, westDistance := self.findValidSample(client, srtmFile, row, column, 0, -1, maxDistance)
+	east, eastDistance := self.findValidSample(client, srtmFile, row, column, 0, 1, maxDistance)
+	north, northDistance := self.findValidSample(client, srtmFile, row, column, -1, 0, maxDistance)
+	south, southDistance := self.findValidSample(client, srtmFile, row, column, 1, 0, maxDistance)
+
+	distance := 0
+	for _, d := range []int{westDistance, eastDistance, northDistance, southDistance} {
+		if d > distance {
+			distance = d
+		}
+	}
+
+	if mode == InterpolationInverseDistance {
+		var sum, weights float64
+		for _, sample := range [][2]float64{{west, float64(westDistance)}, {east, float64(eastDistance)}, {north, float64(northDistance)}, {south, float64(southDistance)}} {
+			if !math.IsNaN(sample[0]) {
+				sum += sample[0] / sample[1]
+				weights += 1 / sample[1]
+			}
+		}
+		if weights == 0 {
+			return math.NaN(), 0
+		}
+		return sum / weights, distance
+	}
+
+	rowEstimate := linearEstimate(west, westDistance, east, eastDistance)
+	columnEstimate := linearEstimate(north, northDistance, south, southDistance)
+
+	if math.IsNaN(rowEstimate) && math.IsNaN(columnEstimate) {
+		return math.NaN(), 0
+	}
+	if math.IsNaN(rowEstimate) {
+		return columnEstimate, distance
+	}
+	if math.IsNaN(columnEstimate) {
+		return rowEstimate, distance
+	}
+	return (rowEstimate + columnEstimate) / 2, distance
+}
+
+// linearEstimate interpolates between two samples found at the given distances on opposite sides (if only one
+// is valid, that one is the estimate)
+func linearEstimate(elevation1 float64, distance1 int, elevation2 float64, distance2 int) float64 {
+	if math.IsNaN(elevation1) {
+		return elevation2
+	}
+	if math.IsNaN(elevation2) {
+		return elevation1
+	}
+	return (elevation1*float64(distance2) + elevation2*float64(distance1)) / float64(distance1+distance2)
+}
+
+// findValidSample walks from (row, column) in the (rowDelta, columnDelta) direction, and returns the first valid
+// sample and its distance (in cells)
+func (self *Srtm) findValidSample(client *http.Client, srtmFile *SrtmFile, row, column, rowDelta, columnDelta, maxDistance int) (float64, int) {
+	for distance := 1; distance <= maxDistance; distance++ {
+		elevation := self.getSample(client, srtmFile, row+rowDelta*distance, column+columnDelta*distance)
+		if !math.IsNaN(elevation) {
+			return elevation, distance
+		}
+	}
+	return math.NaN(), 0
+}
+
+// getSample returns the sample in (row, column) of srtmFile. Rows and columns outside of the tile are read from the
+// neighbouring tiles (which are loaded, if needed). Must be called with the lock held, the lock is released while a
+// neighbouring tile is loaded (so srtmFile must be pinned).
+func (self *Srtm) getSample(client *http.Client, srtmFile *SrtmFile, row, column int) float64 {
+	if row >= 0 && row < srtmFile.squareSize && column >= 0 && column < srtmFile.squareSize {
+		return srtmFile.getElevationFromRowAndColumn(row, column)
+	}
+
+	cellSize := 1 / float64(srtmFile.squareSize-1)
+	latitude := srtmFile.latitude + 1 - float64(row)*cellSize
+	longitude := srtmFile.longitude + float64(column)*cellSize
+	if latitude < -90 || latitude > 90 {
+		return math.NaN()
+	}
+	if longitude < -180 {
+		longitude += 360
+	} else if longitude >= 180 {
+		longitude -= 360
+	}
+
+	name, srtmLatitude, srtmLongitude := self.getSrtmFileNameAndCoordinates(latitude, longitude)
+	neighbour := self.getSrtmFile(name, srtmLatitude, srtmLongitude)
+	if !neighbour.isValidSrtmFile {
+		// Like for the queried points, a missing tile is sea level only within the SRTM coverage:
+		if self.missingTileAsSeaLevel && isInSrtmCoverage(latitude) {
+			return 0
+		}
+		return math.NaN()
+	}
+	if len(neighbour.contents) == 0 {
+		self.useCounter++
+		neighbour.lastUsed = self.useCounter
+		err := self.loadSrtmFileUnlocked(context.Background(), client, neighbour, false)
+		// Not loaded if removed from the cache while the lock was released:
+		if err != nil || len(neighbour.contents) == 0 {
+			self.logLevel.logf(LogWarn, "Error loading %s for interpolation: %v", name, err)
+			return math.NaN()
+		}
+	}
+
+	// Neighbours can have a different resolution, so find the nearest sample:
+	neighbourSize := neighbour.squareSize - 1
+	neighbourRow := clampInt(int(math.Round((neighbour.latitude+1-latitude)*float64(neighbourSize))), 0, neighbourSize)
+	neighbourColumn := clampInt(int(math.Round((longitude-neighbour.longitude)*float64(neighbourSize))), 0, neighbourSize)
+	return neighbour.getElevationFromRowAndColumn(neighbourRow, neighbourColumn)
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,57 @@
+package geoelevations
+
+import (
+	"sync"
+)
+
+// keyedMutex is a mutex per key, mutexes are created when needed and removed when not used anymore
+type keyedMutex struct {
+	lock    sync.Mutex
+	mutexes map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	// users is the number of goroutines holding (or waiting for) the mutex
+	users int
+}
+
+// Lock locks the mutex of the key and returns the function unlocking it
+func (self *keyedMutex) Lock(key string) func() {
+	self.lock.Lock()
+	if self.mutexes == nil {
+		self.mutexes = map[string]*keyedMutexEntry{}
+	}
+	entry, found := self.mutexes[key]
+	if !found {
+		entry = new(keyedMutexEntry)
+		self.mutexes[key] = entry
+	}
+	entry.users++
+	self.lock.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		entry.users--
+		if entry.users == 0 {
+			delete(self.mutexes, key)
+		}
+	}
+}
+
+// storageFileLocks guard the cached files while they are retrieved and saved, so that Srtm instances sharing a
+// storage don't write the same file at the same time
+var storageFileLocks keyedMutex
+
+// lockStorageFile locks the file in the storage (in all the Srtm instances of this process) and returns the function
+// unlocking it
+func lockStorageFile(storage SrtmLocalStorage, fileName string) func() {
+	if pathStorage, ok := storage.(SrtmLocalFilePathStorage); ok {
+		return storageFileLocks.Lock(pathStorage.FilePath(fileName))
+	}
+	return storageFileLocks.Lock(fileName)
+}
@@ -0,0 +1,26 @@
+package geoelevations
+
+import (
+	"log"
+)
+
+// LogLevel is the importance of a log message, see WithLogLevel
+type LogLevel int
+
+const (
+	// LogDebug is for the details of every tile loaded and listing crawled (the default, everything is logged)
+	LogDebug LogLevel = iota
+	// LogInfo is for the less frequent events, like tiles retrieved or the cache cleared
+	LogInfo
+	// LogWarn is for errors which were recovered from, like a failed download retried or a corrupt cached file
+	LogWarn
+	// LogError is for the errors which make an operation fail
+	LogError
+)
+
+// logf logs the message (with log.Printf) if its level is at least the minimum level self
+func (self LogLevel) logf(level LogLevel, format string, args ...interface{}) {
+	if level >= self {
+		log.Printf(format, args...)
+	}
+}
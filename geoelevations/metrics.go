@@ -0,0 +1,43 @@
+package geoelevations
+
+import (
+	"time"
+)
+
+// Metrics receives the events of an Srtm instance, to export them (for example as Prometheus counters and
+// histograms), see WithMetrics. The methods are called from concurrent goroutines, sometimes with internal locks
+// held, so they must be safe for concurrent use, quick, and must not call the Srtm.
+type Metrics interface {
+	// CacheHit is called when a query finds its tile loaded in memory
+	CacheHit(tileName string)
+	// CacheMiss is called when a query has to load its tile (from the storage or server)
+	CacheMiss(tileName string)
+	// DownloadStarted is called before every request of a tile file (retries and mirrors included)
+	DownloadStarted(fileUrl string)
+	// DownloadCompleted is called when the tile file was retrieved, with its (compressed) size
+	DownloadCompleted(fileUrl string, bytes int, duration time.Duration)
+	// DownloadFailed is called when the request failed, or returned something else than the tile file
+	DownloadFailed(fileUrl string, err error, duration time.Duration)
+	// InterpolationFallback is called when the interpolation mode couldn't be applied (near voids or the tile
+	// edges) and the elevation was found with the other mode
+	InterpolationFallback(tileName string, from, to InterpolationMode)
+}
+
+// NoopMetrics ignores all events, it's the default. Embed it in a Metrics implementation to only handle some
+// of the events.
+type NoopMetrics struct{}
+
+func (self NoopMetrics) CacheHit(tileName string)                                            {}
+func (self NoopMetrics) CacheMiss(tileName string)                                           {}
+func (self NoopMetrics) DownloadStarted(fileUrl string)                                      {}
+func (self NoopMetrics) DownloadCompleted(fileUrl string, bytes int, duration time.Duration) {}
+func (self NoopMetrics) DownloadFailed(fileUrl string, err error, duration time.Duration)    {}
+func (self NoopMetrics) InterpolationFallback(tileName string, from, to InterpolationMode)   {}
+
+// metricsOrNoop returns metrics, or NoopMetrics if nil
+func metricsOrNoop(metrics Metrics) Metrics {
+	if metrics == nil {
+		return NoopMetrics{}
+	}
+	return metrics
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package geoelevations
+
+import (
+	"errors"
+)
+
+func mmapFile(fn string) ([]byte, error) {
+	return nil, errors.New("Memory mapping not supported on this platform")
+}
+
+func munmapFile(contents []byte) error {
+	return nil
+}
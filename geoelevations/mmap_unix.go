@@ -0,0 +1,33 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func mmapFile(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, errors.New(fmt.Sprintf("Empty file %s", fn))
+	}
+
+	return syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(contents []byte) error {
+	return syscall.Munmap(contents)
+}
@@ -0,0 +1,280 @@
+package geoelevations
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// SrtmOption configures optional behaviour of Srtm, see the With... functions
+type SrtmOption func(*Srtm)
+
+// WithRateLimit limits outgoing requests (both the crawl of the SRTM directory listings and tile downloads)
+// to requestsPerSecond. When the limit is reached, requests block until allowed, or until their context is done (see
+// WithCrawlTimeout and GetElevationContext). A requestsPerSecond <= 0 means no limit.
+func WithRateLimit(requestsPerSecond float64) SrtmOption {
+	return func(srtm *Srtm) {
+		if requestsPerSecond <= 0 {
+			srtm.limiter = nil
+			return
+		}
+		srtm.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+}
+
+// WithMemoryMappedTiles keeps an unzipped copy of each tile in the storage and memory maps it instead of
+// loading the tile into memory. This way the OS page cache manages which parts are resident, and multiple
+// Srtm instances share the same memory. Works only with storages implementing SrtmLocalFilePathStorage (and
+// falls back to loading into memory otherwise).
+func WithMemoryMappedTiles() SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.memoryMap = true
+	}
+}
+
+// WithLittleEndianTiles reads the samples of the tiles as little-endian. Standard SRTM tiles are big-endian, but
+// some reprocessed datasets aren't. The byte order can't be detected reliably, so it must be known.
+func WithLittleEndianTiles() SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.littleEndian = true
+	}
+}
+
+// WithRangeRequests retrieves only the needed rows of each tile (with HTTP Range requests) instead of downloading
+// whole tiles. Useful for one-off queries of single points. The baseUrl must serve uncompressed tiles, i.e.
+// baseUrl+"N45E013.hgt". If the server doesn't support range requests, the whole file is retrieved instead.
+// Rows retrieved this way are kept in memory but not saved in the storage.
+func WithRangeRequests(baseUrl string) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.rangeBaseUrl = baseUrl
+	}
+}
+
+// WithMaxLoadedTiles limits the number of decompressed tiles kept in memory. When the limit is reached, the least
+// recently used tiles are unloaded (the zipped files are kept in the storage and decompressed again when needed).
+// This is a tradeoff between memory and the CPU time needed to decompress tiles.
+func WithMaxLoadedTiles(maxLoadedTiles int) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.maxLoadedTiles = maxLoadedTiles
+	}
+}
+
+// WithMissingTileAsSeaLevel returns 0 (instead of NaN) for coordinates without a SRTM tile. SRTM has no tiles
+// for areas covered entirely by ocean, so this assumes a missing tile means sea level. Outside of the SRTM coverage
+// (north of 60°N and south of 56°S) missing tiles are not sea level, the error is ErrNoCoverage.
+func WithMissingTileAsSeaLevel() SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.missingTileAsSeaLevel = true
+	}
+}
+
+// WithPrefetchNeighbours starts loading the neighbouring tile(s) in background after serving a point within cells
+// rows/columns of the tile edge (the next query, for example along a track, will probably need them). Tiles are
+// prefetched one at a time, and downloads go through the same client (and rate limit) as other requests.
+func WithPrefetchNeighbours(cells int) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.prefetchCells = cells
+	}
+}
+
+// WithVoidSearchLimit limits how far (in cells) the void interpolation searches for valid samples. If no valid
+// samples are found within the limit, the elevation is NaN. By default, the search goes up to the tile size.
+func WithVoidSearchLimit(cells int) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.voidSearchLimit = cells
+	}
+}
+
+// WithInterpolation sets the interpolation mode, InterpolationNearest by default
+func WithInterpolation(mode InterpolationMode) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.interpolation = mode
+	}
+}
+
+// WithLineOfSightSpacing sets the distance (in meters) between terrain samples in LineOfSight, 30m by default
+func WithLineOfSightSpacing(meters float64) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.lineOfSightSpacing = meters
+	}
+}
+
+// WithChecksums sets the SHA-256 checksums (hex, by tile name) of the tile files, used instead of the ones from
+// the SRTM files index. A downloaded tile is retried if its checksum doesn't match.
+func WithChecksums(checksums map[string]string) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.checksums = checksums
+	}
+}
+
+// WithMirrors sets the servers (directories with the SRTM1/ and SRTM3/ subdirectories) to crawl and retrieve the
+// tiles from, the next one is tried if a server is offline or failing. DefaultMirrors are used by default.
+func WithMirrors(baseUrls ...string) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.mirrors = baseUrls
+	}
+}
+
+// WithCrawlTimeout limits the time the constructor spends crawling the servers for the SRTM files index (which
+// happens if the index is not in the storage yet)
+func WithCrawlTimeout(timeout time.Duration) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.crawlTimeout = timeout
+	}
+}
+
+// WithCrawlConcurrency sets the maximum number of directory listings retrieved in parallel while crawling the
+// servers for the SRTM files index, 4 by default
+func WithCrawlConcurrency(requests int) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.crawlConcurrency = requests
+	}
+}
+
+// WithCrawlDepth sets the number of subdirectory levels crawled below the SRTM1/ and SRTM3/ directories of the
+// servers, 1 by default (for tiles in SRTM3/Eurasia/N45E013.hgt.zip). With 0, only the tiles directly in SRTM1/ and
+// SRTM3/ are found.
+func WithCrawlDepth(levels int) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.crawlDepth = &levels
+	}
+}
+
+// WithCrawlResolutions crawls the servers only for the tiles with these resolutions (for example only SRTM3), the
+// SRTM files index has no tiles of the other resolutions
+func WithCrawlResolutions(resolutions ...SrtmResolution) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.crawlResolutions = resolutions
+	}
+}
+
+// WithIndexRefresh crawls the servers for the SRTM files index again (in the background) every interval, so that
+// newly added tiles are found. The refresh is stopped by Close.
+func WithIndexRefresh(interval time.Duration) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.refreshInterval = interval
+	}
+}
+
+// WithSrtmData uses the given SRTM files index, instead of loading it from the storage (or crawling the servers)
+func WithSrtmData(srtmData *SrtmData) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.srtmData = *srtmData
+		srtm.srtmDataSet = true
+	}
+}
+
+// WithTileTTL makes the tiles cached in the storage expire after ttl, expired tiles are downloaded again (but still
+// used if the download fails). This needs a storage implementing SrtmModTimeStorage. By default cached tiles never
+// expire.
+func WithTileTTL(ttl time.Duration) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.tileTTL = ttl
+	}
+}
+
+// WithMaxStorageBytes limits the size of the tiles cached in the storage, the least recently loaded tiles are
+// deleted when the limit is exceeded. This needs a storage implementing SrtmListingStorage.
+func WithMaxStorageBytes(bytes int64) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.maxStorageBytes = bytes
+	}
+}
+
+// WithMaxRedirects limits the number of redirects followed by a single request (both in the crawl and in the tile
+// downloads), requests redirected more times fail with ErrTooManyRedirects. Zero disables redirects.
+func WithMaxRedirects(redirects int) SrtmOption {
+	return func(srtm *Srtm) {
+		if srtm.redirects == nil {
+			srtm.redirects = &redirectPolicy{}
+		}
+		srtm.redirects.maxRedirects = redirects
+	}
+}
+
+// WithSameHostRedirects follows only redirects to the host of the original request, other redirects fail with
+// ErrRedirectToOtherHost.
+func WithSameHostRedirects() SrtmOption {
+	return func(srtm *Srtm) {
+		if srtm.redirects == nil {
+			srtm.redirects = &redirectPolicy{maxRedirects: defaultMaxRedirects}
+		}
+		srtm.redirects.sameHost = true
+	}
+}
+
+// WithTileFileNames names the files caching the tiles in the storage, for example ResolutionTileFileNames to keep
+// SRTM1 and SRTM3 tiles in different directories. The default is FlatTileFileNames.
+func WithTileFileNames(namer TileFileNamer) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.tileFileNamer = namer
+	}
+}
+
+// WithResolutionPreference sets the order in which resolutions are looked up for tiles available in more than one
+// resolution, for example WithResolutionPreference(SRTM3) to always use SRTM3 (and never download the bigger SRTM1
+// tiles). The default is DefaultResolutionPreference.
+func WithResolutionPreference(resolutions ...SrtmResolution) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.resolutionPreference = resolutions
+	}
+}
+
+// WithTileScheme maps coordinates to tiles (and samples) of a DEM other than SRTM, the tile names are looked up in
+// the SRTM files index as usual. The default is SrtmTileScheme. Functions working on whole areas (RegionStats,
+// FindPeaks, void interpolation across tile edges, ...) still expect one degree tiles.
+func WithTileScheme(scheme TileScheme) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.tileScheme = scheme
+	}
+}
+
+// WithProfileInterpolation sets the interpolation of the elevations of profiles (see ResampleTrack), the default
+// is InterpolationBilinear
+func WithProfileInterpolation(mode InterpolationMode) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.profileInterpolation = &mode
+	}
+}
+
+// WithVoidFill returns elevation (instead of NaN) for points in voids which can't be interpolated. Points not covered
+// by any tile are still NaN.
+func WithVoidFill(elevation float64) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.voidFill = &elevation
+	}
+}
+
+// WithElevationFloor raises the elevations below the floor to it, for example 0 to hide the noise (-1, -2 meters)
+// near coastlines. Off by default, since some regions are genuinely below sea level. Only the elevations of points
+// (GetElevation, tracks, profiles, grids and the images rendered from them) are clamped, region statistics and
+// TileImage use the samples as they are.
+func WithElevationFloor(floor float64) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.elevationFloor = &floor
+	}
+}
+
+// WithVoidFillTileMean is like WithVoidFill, with the mean elevation of the tile as the fill value. Tiles read with
+// range requests (or without valid samples) use the WithVoidFill value, if any.
+func WithVoidFillTileMean() SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.voidFillTileMean = true
+	}
+}
+
+// WithMetrics sends the cache, download and interpolation events to metrics (see Metrics), they are ignored by
+// default
+func WithMetrics(metrics Metrics) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.metrics = metrics
+	}
+}
+
+// WithLogLevel only logs the messages with at least the level, for example LogWarn to only log errors. Everything is
+// logged by default (LogDebug).
+func WithLogLevel(level LogLevel) SrtmOption {
+	return func(srtm *Srtm) {
+		srtm.logLevel = level
+	}
+}
@@ -0,0 +1,37 @@
+// Package orbelevations bridges geoelevations with the github.com/paulmach/orb geometry types (which are
+// longitude, latitude ordered)
+package orbelevations
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/tkrajina/go-elevations/geoelevations"
+)
+
+// ElevationAt returns the elevation (NaN if unknown) of the point
+func ElevationAt(provider geoelevations.ElevationProvider, p orb.Point) (float64, error) {
+	return provider.GetElevation(p.Lat(), p.Lon())
+}
+
+// ElevationsAt returns the elevations (NaN if unknown) of the points of the line string
+func ElevationsAt(srtm *geoelevations.Srtm, ls orb.LineString) ([]float64, error) {
+	return srtm.GetElevationsForPoints(toPoints(ls))
+}
+
+// ProfileAlong returns the elevation profile with points every interval meters along the line string, see
+// geoelevations.Srtm.ResampleTrack
+func ProfileAlong(srtm *geoelevations.Srtm, ls orb.LineString, interval float64) ([]geoelevations.ProfilePoint, error) {
+	return srtm.ResampleTrackForPoints(toPoints(ls), interval)
+}
+
+// ProfilePoint returns the coordinates of the profile point as an orb.Point
+func ProfilePoint(point geoelevations.ProfilePoint) orb.Point {
+	return orb.Point{point.Longitude, point.Latitude}
+}
+
+func toPoints(ls orb.LineString) []geoelevations.Point {
+	result := make([]geoelevations.Point, len(ls))
+	for n, p := range ls {
+		result[n] = geoelevations.Point{Lat: p.Lat(), Lon: p.Lon()}
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package orbelevations
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+	"github.com/tkrajina/go-elevations/geoelevations"
+)
+
+// newTestSrtm serves a N45E013 tile where the elevation of every sample is its column, so that swapped
+// coordinates give other elevations (or no tile)
+func newTestSrtm(t *testing.T) *geoelevations.Srtm {
+	contents := make([]byte, 1201*1201*2)
+	for row := 0; row < 1201; row++ {
+		for column := 0; column < 1201; column++ {
+			i := (row*1201 + column) * 2
+			contents[i], contents[i+1] = byte(column>>8), byte(column)
+		}
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.Create("N45E013.hgt")
+	assert.Nil(t, err)
+	_, err = f.Write(contents)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(buf.Bytes())
+	}))
+	t.Cleanup(server.Close)
+
+	srtmData := &geoelevations.SrtmData{
+		Srtm3BaseUrl: server.URL + "/",
+		Srtm3:        []geoelevations.SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}},
+	}
+	srtm, err := geoelevations.NewSrtmWithCustomStorage(http.DefaultClient, geoelevations.NewMemorySrtmStorage(), geoelevations.WithSrtmData(srtmData))
+	assert.Nil(t, err)
+	return srtm
+}
+
+func TestElevationAt(t *testing.T) {
+	srtm := newTestSrtm(t)
+
+	expected, err := srtm.GetElevation(45.5, 13.75)
+	assert.Nil(t, err)
+	assert.Equal(t, 900.0, expected)
+	elevation, err := ElevationAt(srtm, orb.Point{13.75, 45.5})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, elevation)
+
+	elevations, err := ElevationsAt(srtm, orb.LineString{{13.25, 45.5}, {13.75, 45.5}})
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{300, 900}, elevations)
+}
+
+func TestProfileAlong(t *testing.T) {
+	srtm := newTestSrtm(t)
+
+	// West to east, about 3.9km:
+	profile, err := ProfileAlong(srtm, orb.LineString{{13.25, 45.5}, {13.3, 45.5}}, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(profile))
+	assert.Equal(t, orb.Point{13.25, 45.5}, ProfilePoint(profile[0]))
+	assert.Equal(t, orb.Point{13.3, 45.5}, ProfilePoint(profile[len(profile)-1]))
+	for n, point := range profile {
+		assert.InDelta(t, 45.5, point.Latitude, 0.000001)
+		if n > 0 {
+			assert.Greater(t, point.Longitude, profile[n-1].Longitude)
+			assert.Greater(t, point.Elevation, profile[n-1].Elevation)
+		}
+	}
+	assert.InDelta(t, 300, profile[0].Elevation, 0.000001)
+	assert.InDelta(t, 360, profile[len(profile)-1].Elevation, 0.000001)
+}
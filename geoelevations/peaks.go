@@ -0,0 +1,143 @@
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Peak is a local maximum of the terrain
+type Peak struct {
+	Latitude, Longitude, Elevation float64
+	// Prominence is how far down (in meters) one must descend from the peak before reaching higher ground. Only
+	// the grid is considered, so peaks near the edges (and the highest peak in the grid) can be more prominent.
+	Prominence float64
+}
+
+// FindPeaks samples the bounding box (with resolution samples per degree) and returns the peaks, see
+// ElevationGrid.Peaks
+func (self *Srtm) FindPeaks(minLat, minLon, maxLat, maxLon float64, resolution, window int, minProminence float64) ([]Peak, error) {
+	if window < 1 {
+		return nil, errors.New(fmt.Sprintf("Invalid window: %d", window))
+	}
+	grid, err := self.GetGrid(minLat, minLon, maxLat, maxLon, resolution)
+	if err != nil {
+		return nil, err
+	}
+	return grid.Peaks(window, minProminence), nil
+}
+
+// Peaks returns the cells higher than all the other cells within window cells (in every direction) and with at
+// least minProminence meters of prominence, from the highest down
+func (self *ElevationGrid) Peaks(window int, minProminence float64) []Peak {
+	prominences := self.prominences()
+
+	result := []Peak{}
+	for i, elevation := range self.Elevations {
+		if math.IsNaN(elevation) || prominences[i] < minProminence {
+			continue
+		}
+		row, column := i/self.Columns, i%self.Columns
+		if !self.isLocalMaximum(row, column, window) {
+			continue
+		}
+		latitude, longitude := self.CellCenter(row, column)
+		result = append(result, Peak{Latitude: latitude, Longitude: longitude, Elevation: elevation, Prominence: prominences[i]})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Elevation != result[j].Elevation {
+			return result[i].Elevation > result[j].Elevation
+		}
+		if result[i].Latitude != result[j].Latitude {
+			return result[i].Latitude > result[j].Latitude
+		}
+		return result[i].Longitude < result[j].Longitude
+	})
+	return result
+}
+
+func (self *ElevationGrid) isLocalMaximum(row, column, window int) bool {
+	elevation := self.Get(row, column)
+	for r := row - window; r <= row+window; r++ {
+		for c := column - window; c <= column+window; c++ {
+			if r < 0 || c < 0 || r >= self.Rows || c >= self.Columns || (r == row && c == column) {
+				continue
+			}
+			if other := self.Get(r, c); !math.IsNaN(other) && other >= elevation {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// prominences finds the prominence of every cell which is the highest in its area (0 for the other cells). Cells
+// are added from the highest down, joining the areas of their neighbours, when two areas join the lower peak's
+// prominence is the difference between its elevation and the elevation of the cell joining them.
+func (self *ElevationGrid) prominences() []float64 {
+	prominences := make([]float64, len(self.Elevations))
+	cells := []int{}
+	lowest := math.Inf(1)
+	for i, elevation := range self.Elevations {
+		if !math.IsNaN(elevation) {
+			cells = append(cells, i)
+			lowest = math.Min(lowest, elevation)
+		}
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		return self.Elevations[cells[i]] > self.Elevations[cells[j]]
+	})
+
+	// Union-find of the areas, with the highest cell (peak) of every area:
+	parents := make([]int, len(self.Elevations))
+	peaks := make([]int, len(self.Elevations))
+	for i := range parents {
+		parents[i] = -1
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for _, i := range cells {
+		parents[i], peaks[i] = i, i
+		row, column := i/self.Columns, i%self.Columns
+		for r := row - 1; r <= row+1; r++ {
+			for c := column - 1; c <= column+1; c++ {
+				if r < 0 || c < 0 || r >= self.Rows || c >= self.Columns {
+					continue
+				}
+				neighbour := r*self.Columns + c
+				if parents[neighbour] < 0 {
+					continue
+				}
+				root, neighbourRoot := find(i), find(neighbour)
+				if root == neighbourRoot {
+					continue
+				}
+				higher, lower := root, neighbourRoot
+				if self.Elevations[peaks[lower]] > self.Elevations[peaks[higher]] {
+					higher, lower = lower, higher
+				}
+				// The cell itself (joining its first area) is never a peak:
+				if peaks[lower] != i {
+					prominences[peaks[lower]] = self.Elevations[peaks[lower]] - self.Elevations[i]
+				}
+				parents[lower] = higher
+			}
+		}
+	}
+
+	for _, i := range cells {
+		if find(i) == i {
+			prominences[peaks[i]] = self.Elevations[peaks[i]] - lowest
+		}
+	}
+
+	return prominences
+}
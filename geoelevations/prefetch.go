@@ -0,0 +1,115 @@
+package geoelevations
+
+import (
+	"context"
+)
+
+type prefetchRequest struct {
+	name                string
+	latitude, longitude float64
+}
+
+// prefetchNeighbours queues the neighbouring tiles if (latitude, longitude) is near the edge of srtmFile, must be
+// called with the lock held
+func (self *Srtm) prefetchNeighbours(srtmFile *SrtmFile, latitude, longitude float64) {
+	if srtmFile.squareSize <= 0 {
+		return
+	}
+
+	row, column := srtmFile.getRowAndColumn(latitude, longitude)
+	last := srtmFile.squareSize - 1
+
+	latitudeDeltas := []float64{0}
+	if row <= self.prefetchCells {
+		latitudeDeltas = append(latitudeDeltas, 1)
+	}
+	if row >= last-self.prefetchCells {
+		latitudeDeltas = append(latitudeDeltas, -1)
+	}
+	longitudeDeltas := []float64{0}
+	if column <= self.prefetchCells {
+		longitudeDeltas = append(longitudeDeltas, -1)
+	}
+	if column >= last-self.prefetchCells {
+		longitudeDeltas = append(longitudeDeltas, 1)
+	}
+
+	for _, latitudeDelta := range latitudeDeltas {
+		for _, longitudeDelta := range longitudeDeltas {
+			if latitudeDelta == 0 && longitudeDelta == 0 {
+				continue
+			}
+			neighbourLatitude := srtmFile.latitude + 0.5 + latitudeDelta
+			if neighbourLatitude < -90 || neighbourLatitude > 90 {
+				continue
+			}
+			neighbourLongitude := srtmFile.longitude + 0.5 + longitudeDelta
+			if neighbourLongitude < -180 {
+				neighbourLongitude += 360
+			}
+			name, _, _ := self.getSrtmFileNameAndCoordinates(normalizeCoordinates(neighbourLatitude, neighbourLongitude))
+			if neighbour, found := self.cache[name]; found && (neighbour.isLoaded() || !neighbour.isValidSrtmFile) {
+				continue
+			}
+			self.queuePrefetch(prefetchRequest{name: name, latitude: neighbourLatitude, longitude: neighbourLongitude})
+		}
+	}
+}
+
+// queuePrefetch starts the prefetch worker (if not already running) and queues the request, must be called with
+// the lock held
+func (self *Srtm) queuePrefetch(request prefetchRequest) {
+	if self.prefetchQueue == nil {
+		self.prefetchQueue = make(chan prefetchRequest, 16)
+		self.prefetchDone = make(chan struct{})
+		go self.prefetchWorker(self.prefetchQueue, self.prefetchDone)
+	}
+
+	select {
+	case self.prefetchQueue <- request:
+	default:
+		self.logLevel.logf(LogWarn, "Prefetch queue full, skipping %s", request.name)
+	}
+}
+
+func (self *Srtm) prefetchWorker(queue chan prefetchRequest, done chan struct{}) {
+	defer close(done)
+	for request := range queue {
+		self.prefetch(request)
+	}
+}
+
+func (self *Srtm) prefetch(request prefetchRequest) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	name, srtmLatitude, srtmLongitude := self.getSrtmFileNameAndCoordinates(request.latitude, request.longitude)
+	srtmFile := self.getSrtmFile(name, srtmLatitude, srtmLongitude)
+	// Tiles read with range requests are not prefetched, that would download the whole file:
+	if !srtmFile.isValidSrtmFile || srtmFile.isLoaded() || len(srtmFile.rangeUrl) > 0 {
+		return
+	}
+
+	self.logLevel.logf(LogDebug, "Prefetching %s", name)
+	self.useCounter++
+	srtmFile.lastUsed = self.useCounter
+	if err := self.loadSrtmFileUnlocked(context.Background(), self.httpClient(self.client), srtmFile, true); err != nil {
+		self.logLevel.logf(LogWarn, "Error prefetching %s: %s", name, err.Error())
+	}
+	if self.maxLoadedTiles > 0 {
+		self.unloadLeastRecentlyUsed(self.maxLoadedTiles)
+	}
+}
+
+// stopPrefetch stops the prefetch worker (if running) and waits for it to finish
+func (self *Srtm) stopPrefetch() {
+	self.lock.Lock()
+	queue, done := self.prefetchQueue, self.prefetchDone
+	self.prefetchQueue, self.prefetchDone = nil, nil
+	self.lock.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
@@ -0,0 +1,157 @@
+package geoelevations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ProfilePoint is a point of an elevation profile
+type ProfilePoint struct {
+	Latitude, Longitude float64
+	// Distance (in meters) from the start of the profile, along the ground (great-circle)
+	Distance float64
+	// Distance3D (in meters) from the start of the profile, including the climbs and descents (along the
+	// straight lines between the points). Elevation changes to or from unknown elevations are ignored.
+	Distance3D float64
+	// Elevation is NaN if unknown
+	Elevation float64
+}
+
+// ResampleTrack returns points every intervalMeters along the track ({latitude, longitude} pairs), with the
+// coordinates interpolated linearly between the track points. Elevations are interpolated bilinearly, unless
+// configured otherwise with WithProfileInterpolation. The last point is always the end of the track, even
+// if it is closer than intervalMeters to the previous one.
+func (self *Srtm) ResampleTrack(points [][2]float64, intervalMeters float64) ([]ProfilePoint, error) {
+	if intervalMeters <= 0 || math.IsNaN(intervalMeters) {
+		return nil, errors.New(fmt.Sprintf("Invalid interval: %f", intervalMeters))
+	}
+
+	result := make([]ProfilePoint, 0)
+	if len(points) == 0 {
+		return result, nil
+	}
+
+	next, start := 0.0, 0.0
+	for n := 0; n < len(points); n++ {
+		from, to := points[n], points[n]
+		if n+1 < len(points) {
+			to = points[n+1]
+		}
+		length := haversineDistance(from[0], from[1], to[0], to[1])
+		for next <= start+length {
+			fraction := 0.0
+			if length > 0 {
+				fraction = (next - start) / length
+			}
+			result = append(result, ProfilePoint{
+				Latitude:  from[0] + (to[0]-from[0])*fraction,
+				Longitude: from[1] + (to[1]-from[1])*fraction,
+				Distance:  next,
+			})
+			next += intervalMeters
+		}
+		start += length
+	}
+	if last := points[len(points)-1]; result[len(result)-1].Distance < start-1e-6 {
+		result = append(result, ProfilePoint{Latitude: last[0], Longitude: last[1], Distance: start})
+	}
+
+	for n := range result {
+		elevation, err := self.getProfileElevation(result[n].Latitude, result[n].Longitude)
+		if err != nil {
+			return nil, err
+		}
+		result[n].Elevation = elevation
+	}
+	for n := 1; n < len(result); n++ {
+		dx, dy := result[n].Distance-result[n-1].Distance, result[n].Elevation-result[n-1].Elevation
+		if math.IsNaN(dy) {
+			dy = 0
+		}
+		result[n].Distance3D = result[n-1].Distance3D + math.Hypot(dx, dy)
+	}
+
+	return result, nil
+}
+
+// getProfileElevation returns the elevation of a profile point (NaN if out of the SRTM coverage) with the profile
+// interpolation mode
+func (self *Srtm) getProfileElevation(latitude, longitude float64) (float64, error) {
+	mode := InterpolationBilinear
+	if self.profileInterpolation != nil {
+		mode = *self.profileInterpolation
+	}
+	details, err := self.getElevationDetails(context.Background(), self.client, latitude, longitude, mode)
+	if errors.Is(err, ErrNoCoverage) {
+		return math.NaN(), nil
+	}
+	return details.Elevation, err
+}
+
+// ResampleTrackForPoints is like ResampleTrack, with Point coordinates
+func (self *Srtm) ResampleTrackForPoints(points []Point, intervalMeters float64) ([]ProfilePoint, error) {
+	return self.ResampleTrack(pointPairs(points), intervalMeters)
+}
+
+// SimplifyProfile removes points (Ramer-Douglas-Peucker) which are closer than toleranceMeters to the simplified
+// distance/elevation curve. The first and last points are always kept, the other points without an elevation are
+// removed (the curve is simplified between the first and last known elevations).
+func SimplifyProfile(profile []ProfilePoint, toleranceMeters float64) []ProfilePoint {
+	if len(profile) <= 2 {
+		return append([]ProfilePoint(nil), profile...)
+	}
+
+	keep := make([]bool, len(profile))
+	keep[0], keep[len(profile)-1] = true, true
+
+	// Indexes of the points with an elevation:
+	known := make([]int, 0, len(profile))
+	for n, point := range profile {
+		if !math.IsNaN(point.Elevation) {
+			known = append(known, n)
+		}
+	}
+	if len(known) > 0 {
+		keep[known[0]], keep[known[len(known)-1]] = true, true
+	}
+
+	// Ranges (of known) still to simplify (instead of recursion, which could be deep for long profiles):
+	ranges := [][2]int{{0, len(known) - 1}}
+	for len(ranges) > 0 {
+		first, last := ranges[len(ranges)-1][0], ranges[len(ranges)-1][1]
+		ranges = ranges[:len(ranges)-1]
+
+		farthest, maxDistance := -1, toleranceMeters
+		for n := first + 1; n < last; n++ {
+			if distance := profileDistance(profile[known[n]], profile[known[first]], profile[known[last]]); distance > maxDistance {
+				farthest, maxDistance = n, distance
+			}
+		}
+		if farthest >= 0 {
+			keep[known[farthest]] = true
+			ranges = append(ranges, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	result := make([]ProfilePoint, 0)
+	for n, point := range profile {
+		if keep[n] {
+			result = append(result, point)
+		}
+	}
+	return result
+}
+
+// profileDistance is the distance (in meters) of the point from the segment in the distance/elevation plane
+func profileDistance(point, first, last ProfilePoint) float64 {
+	dx, dy := last.Distance-first.Distance, last.Elevation-first.Elevation
+	px, py := point.Distance-first.Distance, point.Elevation-first.Elevation
+
+	fraction := 0.0
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		fraction = math.Max(0, math.Min(1, (px*dx+py*dy)/lengthSquared))
+	}
+	return math.Hypot(px-fraction*dx, py-fraction*dy)
+}
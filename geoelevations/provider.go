@@ -0,0 +1,79 @@
+package geoelevations
+
+import (
+	"math"
+)
+
+// ElevationProvider is implemented by everything able to return elevations (Srtm, ChainProvider, ...). Code
+// depending on this interface (instead of *Srtm) can be tested with a fake provider, like StaticProvider.
+type ElevationProvider interface {
+	GetElevation(latitude, longitude float64) (float64, error)
+}
+
+// ChainProvider queries the providers in order, until one of them returns an elevation (not NaN) without error.
+// For example: SRTM1 first, then SRTM3, then a coarse global fallback.
+type ChainProvider struct {
+	providers []ElevationProvider
+	logLevel  LogLevel
+}
+
+func NewChainProvider(providers ...ElevationProvider) *ChainProvider {
+	return &ChainProvider{providers: providers}
+}
+
+// SetLogLevel only logs the messages with at least the level (the errors of the providers are LogWarn), see
+// WithLogLevel
+func (self *ChainProvider) SetLogLevel(level LogLevel) *ChainProvider {
+	self.logLevel = level
+	return self
+}
+
+// GetElevation returns the first valid elevation. If no provider has one, the result is NaN with the last error
+// (if any).
+func (self *ChainProvider) GetElevation(latitude, longitude float64) (float64, error) {
+	var lastErr error
+	for _, provider := range self.providers {
+		elevation, err := provider.GetElevation(latitude, longitude)
+		if err != nil {
+			self.logLevel.logf(LogWarn, "Error retrieving elevation for (%f, %f): %s", latitude, longitude, err.Error())
+			lastErr = err
+			continue
+		}
+		if !math.IsNaN(elevation) {
+			return elevation, nil
+		}
+	}
+	return math.NaN(), lastErr
+}
+
+// StaticProvider returns fixed elevations for fixed coordinates, useful as a fake provider in tests
+type StaticProvider struct {
+	// Elevations by {latitude, longitude}
+	Elevations map[[2]float64]float64
+	// Default is returned for coordinates not in Elevations
+	Default float64
+}
+
+// NewStaticProvider creates a StaticProvider returning NaN for unknown coordinates
+func NewStaticProvider(elevations map[[2]float64]float64) *StaticProvider {
+	if elevations == nil {
+		elevations = make(map[[2]float64]float64)
+	}
+	return &StaticProvider{Elevations: elevations, Default: math.NaN()}
+}
+
+func (self *StaticProvider) Set(latitude, longitude, elevation float64) *StaticProvider {
+	self.Elevations[[2]float64{latitude, longitude}] = elevation
+	return self
+}
+
+func (self *StaticProvider) GetElevation(latitude, longitude float64) (float64, error) {
+	if elevation, found := self.Elevations[[2]float64{latitude, longitude}]; found {
+		return elevation, nil
+	}
+	return self.Default, nil
+}
+
+var _ ElevationProvider = new(Srtm)
+var _ ElevationProvider = new(ChainProvider)
+var _ ElevationProvider = new(StaticProvider)
@@ -0,0 +1,93 @@
+package geoelevations
+
+import (
+	"context"
+	"time"
+)
+
+// newCrawler creates a crawler with the client and crawl options of this Srtm
+func (self *Srtm) newCrawler(ctx context.Context) *srtmCrawler {
+	crawler := newSrtmCrawler(ctx, self.httpClient(self.client))
+	if self.crawlConcurrency > 0 {
+		crawler.requests = make(chan struct{}, self.crawlConcurrency)
+	}
+	if self.crawlDepth != nil {
+		crawler.maxDepth = *self.crawlDepth
+	}
+	crawler.resolutions = self.crawlResolutions
+	crawler.logLevel = self.logLevel
+	return crawler
+}
+
+func (self *Srtm) startIndexRefresh() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.refreshStop != nil {
+		return
+	}
+	self.refreshStop = make(chan struct{})
+	self.refreshDone = make(chan struct{})
+	go self.refreshWorker(self.refreshStop, self.refreshDone)
+}
+
+func (self *Srtm) refreshWorker(stop, done chan struct{}) {
+	defer close(done)
+
+	// A crawl in progress is cancelled on stop:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(self.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := self.refreshIndex(ctx); err != nil {
+				self.logLevel.logf(LogError, "Error refreshing the SRTM files index: %s", err.Error())
+			}
+		}
+	}
+}
+
+// refreshIndex crawls the servers and replaces the SRTM files index
+func (self *Srtm) refreshIndex(ctx context.Context) error {
+	crawler := self.newCrawler(ctx)
+	crawler.loadListings(self.storage)
+	srtmData, err := crawler.loadSrtmDataFromMirrors(self.mirrors)
+	if err != nil {
+		return err
+	}
+	if err := crawler.saveListings(self.storage); err != nil {
+		return err
+	}
+	if _, err := saveSrtmData(self.storage, srtmData); err != nil {
+		return err
+	}
+
+	self.SetSrtmData(srtmData)
+
+	self.logLevel.logf(LogInfo, "Refreshed the SRTM files index")
+	return nil
+}
+
+func (self *Srtm) stopIndexRefresh() {
+	self.lock.Lock()
+	stop, done := self.refreshStop, self.refreshDone
+	self.refreshStop, self.refreshDone = nil, nil
+	self.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
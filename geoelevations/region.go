@@ -0,0 +1,305 @@
+package geoelevations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ElevationStats are aggregate statistics of the samples in a region
+type ElevationStats struct {
+	// Min, Max and Mean are NaN if there are no valid samples
+	Min, Max, Mean float64
+	// Count is the number of valid samples
+	Count int
+	// Voids is the number of void samples (not included in Min, Max and Mean)
+	Voids int
+}
+
+// RegionStats returns the min, max and mean elevation of all the samples within the bounding box
+func (self *Srtm) RegionStats(minLat, minLon, maxLat, maxLon float64) (ElevationStats, error) {
+	stats := ElevationStats{Min: math.NaN(), Max: math.NaN(), Mean: math.NaN()}
+
+	var sum float64
+	err := self.forEachSampleInRegion(minLat, minLon, maxLat, maxLon, func(latitude, longitude, elevation float64) {
+		if math.IsNaN(elevation) {
+			stats.Voids++
+			return
+		}
+		if stats.Count == 0 || elevation < stats.Min {
+			stats.Min = elevation
+		}
+		if stats.Count == 0 || elevation > stats.Max {
+			stats.Max = elevation
+		}
+		sum += elevation
+		stats.Count++
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.Count > 0 {
+		stats.Mean = sum / float64(stats.Count)
+	}
+	return stats, nil
+}
+
+// HistogramVoids is the RegionHistogram key of the void samples
+const HistogramVoids = math.MinInt32
+
+// RegionHistogram counts the samples within the bounding box by elevation, the keys are the lower bounds of the
+// binSize (in meters) wide bins. Voids are counted under HistogramVoids.
+func (self *Srtm) RegionHistogram(minLat, minLon, maxLat, maxLon float64, binSize float64) (map[int]int, error) {
+	if binSize <= 0 || math.IsNaN(binSize) || math.IsInf(binSize, 0) {
+		return nil, errors.New(fmt.Sprintf("Invalid bin size: %f", binSize))
+	}
+
+	histogram := map[int]int{}
+	err := self.forEachSampleInRegion(minLat, minLon, maxLat, maxLon, func(latitude, longitude, elevation float64) {
+		if math.IsNaN(elevation) {
+			histogram[HistogramVoids]++
+			return
+		}
+		histogram[int(math.Floor(elevation/binSize)*binSize)]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
+
+// RegionRuggedness returns the mean Terrain Ruggedness Index of the samples within the bounding box, the TRI of a
+// sample being the mean absolute elevation difference (in meters) from its 8 neighbours. Voids are skipped (as
+// samples and as neighbours), the result is NaN if there are no valid samples.
+func (self *Srtm) RegionRuggedness(minLat, minLon, maxLat, maxLon float64) (float64, error) {
+	var sum float64
+	var count int
+	// For the neighbouring tiles (configured once, not for every sample):
+	client := self.httpClient(self.client)
+	err := self.forEachCellInRegion(minLat, minLon, maxLat, maxLon, func(srtmFile *SrtmFile, row, column int, latitude, longitude float64) {
+		elevation := srtmFile.getElevationFromRowAndColumn(row, column)
+		if math.IsNaN(elevation) {
+			return
+		}
+		var differences float64
+		var neighbours int
+		for rowOffset := -1; rowOffset <= 1; rowOffset++ {
+			for columnOffset := -1; columnOffset <= 1; columnOffset++ {
+				if rowOffset == 0 && columnOffset == 0 {
+					continue
+				}
+				// Neighbours across the tile edges are read from the neighbouring tiles:
+				neighbour := self.getSample(client, srtmFile, row+rowOffset, column+columnOffset)
+				if !math.IsNaN(neighbour) {
+					differences += math.Abs(neighbour - elevation)
+					neighbours++
+				}
+			}
+		}
+		if neighbours > 0 {
+			sum += differences / float64(neighbours)
+			count++
+		}
+	})
+	if err != nil {
+		return math.NaN(), err
+	}
+	if count == 0 {
+		return math.NaN(), nil
+	}
+	return sum / float64(count), nil
+}
+
+// VolumeStats are the volumes of a region, see RegionVolume
+type VolumeStats struct {
+	// Above is the volume (in cubic meters) of the terrain above the reference elevation, Below of the space below it
+	// (down to the terrain)
+	Above, Below float64
+	// Count is the number of valid samples
+	Count int
+	// Voids is the number of void samples (not included in Above and Below)
+	Voids int
+}
+
+// RegionVolume returns the volumes of the terrain above referenceElevation and of the space below it within the
+// bounding box. Every sample stands for the cell (of one sample spacing) around it, with the ground area of the cell
+// at its latitude. Voids are excluded (and counted).
+func (self *Srtm) RegionVolume(minLat, minLon, maxLat, maxLon, referenceElevation float64) (VolumeStats, error) {
+	var stats VolumeStats
+	err := self.forEachCellInRegion(minLat, minLon, maxLat, maxLon, func(srtmFile *SrtmFile, row, column int, latitude, longitude float64) {
+		elevation := srtmFile.getElevationFromRowAndColumn(row, column)
+		if math.IsNaN(elevation) {
+			stats.Voids++
+			return
+		}
+		stats.Count++
+		volume := cellArea(latitude, 1/float64(srtmFile.squareSize-1)) * (elevation - referenceElevation)
+		if volume > 0 {
+			stats.Above += volume
+		} else {
+			stats.Below -= volume
+		}
+	})
+	if err != nil {
+		return VolumeStats{}, err
+	}
+	return stats, nil
+}
+
+// cellArea returns the ground area (in square meters) of a cell of cellSize degrees centered on the latitude
+func cellArea(latitude, cellSize float64) float64 {
+	north := math.Min(latitude+cellSize/2, 90)
+	south := math.Max(latitude-cellSize/2, -90)
+	return earthRadius * earthRadius * toRadians(cellSize) * (math.Sin(toRadians(north)) - math.Sin(toRadians(south)))
+}
+
+func validateRegion(minLat, minLon, maxLat, maxLon float64) error {
+	if err := validateCoordinates(minLat, minLon); err != nil {
+		return err
+	}
+	if err := validateCoordinates(maxLat, maxLon); err != nil {
+		return err
+	}
+	if minLat > maxLat || minLon > maxLon {
+		return fmt.Errorf("%w: invalid bounding box (%f, %f) - (%f, %f)", ErrInvalidCoordinate, minLat, minLon, maxLat, maxLon)
+	}
+	return nil
+}
+
+// normalizeRegionLongitudes wraps the longitudes of a bounding box given in the [0, 360] range into [-180, 180]
+func normalizeRegionLongitudes(minLon, maxLon float64) (float64, float64, error) {
+	if maxLon <= 180 {
+		return minLon, maxLon, nil
+	}
+	if minLon < 180 {
+		return 0, 0, fmt.Errorf("%w: bounding box crossing the antimeridian (%f - %f)", ErrInvalidCoordinate, minLon, maxLon)
+	}
+	return minLon - 360, maxLon - 360, nil
+}
+
+// forEachSampleInRegion calls fn with every sample (NaN for voids, no interpolation) of the tiles covering the
+// bounding box (loading them, if needed). Samples on the shared edges of neighbouring tiles are visited only once.
+// Tiles not available are skipped. Longitudes can be in the [0, 360] range, but bounding boxes crossing the
+// antimeridian are not supported (ErrInvalidCoordinate).
+func (self *Srtm) forEachSampleInRegion(minLat, minLon, maxLat, maxLon float64, fn func(latitude, longitude, elevation float64)) error {
+	return self.forEachCellInRegion(minLat, minLon, maxLat, maxLon, func(srtmFile *SrtmFile, row, column int, latitude, longitude float64) {
+		fn(latitude, longitude, srtmFile.getElevationFromRowAndColumn(row, column))
+	})
+}
+
+// forEachCellInRegion is like forEachSampleInRegion, but fn gets the tile (and the row and column) of every sample
+// instead of its elevation, to read the neighbouring samples. fn is called with the lock held.
+func (self *Srtm) forEachCellInRegion(minLat, minLon, maxLat, maxLon float64, fn func(srtmFile *SrtmFile, row, column int, latitude, longitude float64)) error {
+	if err := validateRegion(minLat, minLon, maxLat, maxLon); err != nil {
+		return err
+	}
+	minLon, maxLon, err := normalizeRegionLongitudes(minLon, maxLon)
+	if err != nil {
+		return err
+	}
+
+	for tileLatitude := math.Floor(minLat); tileLatitude <= maxLat && tileLatitude < 90; tileLatitude++ {
+		for tileLongitude := math.Floor(minLon); tileLongitude <= maxLon && tileLongitude < 180; tileLongitude++ {
+			// The south edge belongs to the tile below, except in the southernmost row of tiles:
+			includeSouthEdge := tileLatitude == math.Floor(minLat)
+			// The east edge belongs to the next tile, except in the easternmost column of tiles:
+			includeEastEdge := tileLongitude == math.Floor(maxLon)
+			err := self.forEachSampleInTile(tileLatitude, tileLongitude, includeSouthEdge, includeEastEdge, func(srtmFile *SrtmFile, row, column int, latitude, longitude float64) {
+				if minLat <= latitude && latitude <= maxLat && minLon <= longitude && longitude <= maxLon {
+					fn(srtmFile, row, column, latitude, longitude)
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forEachSampleInTile calls fn for every sample of the tile with the south-west corner in (tileLatitude,
+// tileLongitude), fn is called with the lock held
+func (self *Srtm) forEachSampleInTile(tileLatitude, tileLongitude float64, includeSouthEdge, includeEastEdge bool, fn func(srtmFile *SrtmFile, row, column int, latitude, longitude float64)) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, err := self.loadTile(tileLatitude+0.5, tileLongitude+0.5)
+	if err != nil {
+		return err
+	}
+	if srtmFile == nil {
+		return nil
+	}
+	// Kept while the neighbouring tiles are loaded (with the lock released), by RegionRuggedness:
+	unpin := srtmFile.pin()
+	defer func() {
+		unpin()
+		// The tile (and the neighbours loaded) count in the limit of loaded tiles:
+		if self.maxLoadedTiles > 0 {
+			self.unloadLeastRecentlyUsed(self.maxLoadedTiles)
+		}
+	}()
+
+	last := srtmFile.squareSize - 1
+	lastRow, lastColumn := last-1, last-1
+	if includeSouthEdge {
+		lastRow = last
+	}
+	if includeEastEdge {
+		lastColumn = last
+	}
+
+	for row := 0; row <= lastRow; row++ {
+		latitude := srtmFile.latitude + 1 - float64(row)/float64(last)
+		for column := 0; column <= lastColumn; column++ {
+			longitude := srtmFile.longitude + float64(column)/float64(last)
+			fn(srtmFile, row, column, latitude, longitude)
+		}
+	}
+
+	return nil
+}
+
+// loadTile returns the (loaded) tile covering the point, or nil if there is no tile. Must be called with the lock
+// held, the lock is released while the tile is loaded.
+func (self *Srtm) loadTile(latitude, longitude float64) (*SrtmFile, error) {
+	name, srtmLatitude, srtmLongitude := self.getSrtmFileNameAndCoordinates(latitude, longitude)
+	srtmFile := self.getSrtmFile(name, srtmLatitude, srtmLongitude)
+	if !srtmFile.isValidSrtmFile {
+		return nil, nil
+	}
+
+	self.useCounter++
+	srtmFile.lastUsed = self.useCounter
+	if len(srtmFile.contents) == 0 {
+		self.logLevel.logf(LogDebug, "Loading %s", name)
+		self.stats.Misses++
+		metricsOrNoop(self.metrics).CacheMiss(name)
+		client := self.httpClient(self.client)
+		if err := self.loadSrtmFileUnlocked(context.Background(), client, srtmFile, false); err != nil {
+			return nil, err
+		}
+		// Removed from the cache (and not loaded) while the lock was released:
+		if !srtmFile.isLoaded() {
+			if err := self.loadSrtmFile(client, srtmFile); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		self.stats.Hits++
+		metricsOrNoop(self.metrics).CacheHit(name)
+	}
+	if srtmFile.squareSize <= 0 {
+		return nil, fmt.Errorf("%w: invalid size for file %s: %d", ErrCorruptTile, name, len(srtmFile.contents))
+	}
+	if self.maxLoadedTiles > 0 {
+		unpin := srtmFile.pin()
+		self.unloadLeastRecentlyUsed(self.maxLoadedTiles)
+		unpin()
+	}
+
+	return srtmFile, nil
+}
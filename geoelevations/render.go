@@ -0,0 +1,196 @@
+package geoelevations
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// ColorStop is the color of an elevation in a ColorRamp
+type ColorStop struct {
+	Elevation float64
+	Color     color.RGBA
+}
+
+// ColorRamp maps elevations to colors, interpolating between the stops
+type ColorRamp struct {
+	// Stops sorted by elevation, elevations outside of the stops get the color of the nearest one
+	Stops []ColorStop
+	// Void is the color of unknown elevations
+	Void color.RGBA
+	// BelowSeaLevel is the color of elevations lower than 0
+	BelowSeaLevel color.RGBA
+}
+
+// DefaultColorRamp is the usual hypsometric tinting, from green lowlands to brown and white peaks
+func DefaultColorRamp() ColorRamp {
+	return ColorRamp{
+		Stops: []ColorStop{
+			{Elevation: 0, Color: color.RGBA{R: 0x57, G: 0x9a, B: 0x4a, A: 0xff}},
+			{Elevation: 500, Color: color.RGBA{R: 0xb5, G: 0xc9, B: 0x6a, A: 0xff}},
+			{Elevation: 1000, Color: color.RGBA{R: 0xe3, G: 0xc7, B: 0x7a, A: 0xff}},
+			{Elevation: 2000, Color: color.RGBA{R: 0xa8, G: 0x71, B: 0x3e, A: 0xff}},
+			{Elevation: 3000, Color: color.RGBA{R: 0x8a, G: 0x7b, B: 0x72, A: 0xff}},
+			{Elevation: 4500, Color: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		},
+		Void:          color.RGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff},
+		BelowSeaLevel: color.RGBA{R: 0x3a, G: 0x6e, B: 0xb5, A: 0xff},
+	}
+}
+
+// Color returns the color of the elevation
+func (self ColorRamp) Color(elevation float64) color.RGBA {
+	if math.IsNaN(elevation) || len(self.Stops) == 0 {
+		return self.Void
+	}
+	if elevation < 0 {
+		return self.BelowSeaLevel
+	}
+
+	if elevation <= self.Stops[0].Elevation {
+		return self.Stops[0].Color
+	}
+	for n := 1; n < len(self.Stops); n++ {
+		lower, upper := self.Stops[n-1], self.Stops[n]
+		if elevation <= upper.Elevation {
+			fraction := (elevation - lower.Elevation) / (upper.Elevation - lower.Elevation)
+			return color.RGBA{
+				R: interpolateColorComponent(lower.Color.R, upper.Color.R, fraction),
+				G: interpolateColorComponent(lower.Color.G, upper.Color.G, fraction),
+				B: interpolateColorComponent(lower.Color.B, upper.Color.B, fraction),
+				A: interpolateColorComponent(lower.Color.A, upper.Color.A, fraction),
+			}
+		}
+	}
+	return self.Stops[len(self.Stops)-1].Color
+}
+
+func interpolateColorComponent(from, to uint8, fraction float64) uint8 {
+	return uint8(math.Round(float64(from) + (float64(to)-float64(from))*fraction))
+}
+
+// ColorImage renders the grid (one pixel per cell, north up) with the color ramp
+func (self *ElevationGrid) ColorImage(ramp ColorRamp) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, self.Columns, self.Rows))
+	for row := 0; row < self.Rows; row++ {
+		for column := 0; column < self.Columns; column++ {
+			img.SetRGBA(column, row, ramp.Color(self.Get(row, column)))
+		}
+	}
+	return img
+}
+
+// WriteColorPNG renders the bounding box (with resolution pixels per degree) with the color ramp as a PNG image
+func (self *Srtm) WriteColorPNG(w io.Writer, minLat, minLon, maxLat, maxLon float64, resolution int, ramp ColorRamp) error {
+	grid, err := self.GetGrid(minLat, minLon, maxLat, maxLon, resolution)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, grid.ColorImage(ramp))
+}
+
+// NormalMap renders the surface normals of the grid (one pixel per cell, north up) as a tangent space normal map,
+// the x (east), y (north) and z (up) components are encoded (from [-1, 1] to [0, 255]) in the red, green and blue
+// channel. Elevations are multiplied by verticalExaggeration.
+func (self *ElevationGrid) NormalMap(verticalExaggeration float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, self.Columns, self.Rows))
+	for row := 0; row < self.Rows; row++ {
+		for column := 0; column < self.Columns; column++ {
+			east, north := self.gradient(row, column)
+			x, y, z := -east*verticalExaggeration, -north*verticalExaggeration, 1.0
+			length := math.Sqrt(x*x + y*y + z*z)
+			img.SetRGBA(column, row, color.RGBA{
+				R: encodeNormalComponent(x / length),
+				G: encodeNormalComponent(y / length),
+				B: encodeNormalComponent(z / length),
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
+
+func encodeNormalComponent(component float64) uint8 {
+	return uint8(math.Round((component + 1) / 2 * 255))
+}
+
+// WriteNormalMapPNG renders the bounding box (with resolution pixels per degree) as a normal map PNG image, see
+// ElevationGrid.NormalMap
+func (self *Srtm) WriteNormalMapPNG(w io.Writer, minLat, minLon, maxLat, maxLon float64, resolution int, verticalExaggeration float64) error {
+	grid, err := self.GetGrid(minLat, minLon, maxLat, maxLon, resolution)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, grid.NormalMap(verticalExaggeration))
+}
+
+// TileVoidGray is the gray value of voids in tile images
+const TileVoidGray = 0
+
+// tileImage is a image.Image (with color.Gray16 pixels) over the samples of a tile
+type tileImage struct {
+	contents     []byte
+	squareSize   int
+	littleEndian bool
+}
+
+func (self *tileImage) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+func (self *tileImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, self.squareSize, self.squareSize)
+}
+
+// At returns the sample as Gray16 with Y = elevation + 32768 (or TileVoidGray for voids)
+func (self *tileImage) At(x, y int) color.Color {
+	if x < 0 || y < 0 || x >= self.squareSize || y >= self.squareSize {
+		return color.Gray16{}
+	}
+	i := (y*self.squareSize + x) * 2
+	byte1, byte2 := self.contents[i], self.contents[i+1]
+	if self.littleEndian {
+		byte1, byte2 = byte2, byte1
+	}
+	elevation := decodeElevation(byte1, byte2)
+	if math.IsNaN(elevation) {
+		return color.Gray16{Y: TileVoidGray}
+	}
+	return color.Gray16{Y: uint16(int(elevation) - voidValue)}
+}
+
+// Image returns the (loaded) tile as an image, with one pixel per sample (north up), nil if the tile is not loaded
+func (self *SrtmFile) Image() image.Image {
+	if len(self.contents) == 0 || self.squareSize <= 0 {
+		return nil
+	}
+	contents := self.contents
+	// Memory mapped contents are invalid after the tile is unloaded:
+	if self.mapped {
+		contents = append([]byte(nil), contents...)
+	}
+	return &tileImage{contents: contents, squareSize: self.squareSize, littleEndian: self.littleEndian}
+}
+
+// TileImage loads the tile (for example "N45E013") and returns it as an image, see SrtmFile.Image
+func (self *Srtm) TileImage(name string) (image.Image, error) {
+	minLatitude, minLongitude, _, _, ok := TileBounds(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid tile name %s", ErrInvalidCoordinate, name)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, err := self.loadTile(minLatitude+0.5, minLongitude+0.5)
+	if err != nil {
+		return nil, err
+	}
+	if srtmFile == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTileNotAvailable, name)
+	}
+	return srtmFile.Image(), nil
+}
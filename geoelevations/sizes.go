@@ -0,0 +1,66 @@
+package geoelevations
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TileSizes returns the sizes (in bytes) of the files which would be downloaded for the tiles (for example
+// "N45E013"), from the Content-Length of HEAD requests, without downloading them. The size is -1 if the server
+// doesn't report it. Tiles which can't be downloaded (not in the index, or failing on the server) are not in the
+// result, the error is an *ElevationsError with their errors.
+func (self *Srtm) TileSizes(srtmFileNames ...string) (map[string]int64, error) {
+	client := self.httpClient(self.client)
+	result := map[string]int64{}
+	errs := map[string]error{}
+	for _, name := range srtmFileNames {
+		self.srtmDataLock.RLock()
+		baseUrl, srtmUrl, resolution := self.srtmData.getBestSrtmUrl(name, self.resolutionPreference)
+		self.srtmDataLock.RUnlock()
+		if srtmUrl == nil {
+			errs[name] = fmt.Errorf("%w: %s not in the SRTM files index", ErrTileNotAvailable, name)
+			continue
+		}
+
+		fileUrl := newSrtmFile(name, baseUrl+srtmUrl.Url, resolution, 0, 0).fileUrl
+		size, err := getContentLength(client, fileUrl)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		result[name] = size
+	}
+
+	if len(errs) > 0 {
+		return result, &ElevationsError{Errors: errs}
+	}
+	return result, nil
+}
+
+// getContentLength requests the headers of the file with HEAD, or with GET (without reading the body) if the
+// server doesn't support HEAD
+func getContentLength(client *http.Client, fileUrl string) (int64, error) {
+	var response *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, fileUrl, nil)
+		if err != nil {
+			return 0, err
+		}
+		response, err = client.Do(req)
+		if err != nil {
+			if redirectErr := asRedirectError(err); redirectErr != nil {
+				return 0, fmt.Errorf("%w: error retrieving %s", redirectErr, fileUrl)
+			}
+			return 0, fmt.Errorf("%w: error retrieving %s: %s", ErrOffline, fileUrl, err.Error())
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusMethodNotAllowed && response.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: %s returned %s", ErrTileNotAvailable, fileUrl, response.Status)
+	}
+	return response.ContentLength, nil
+}
@@ -1,89 +1,675 @@
 package geoelevations
 
 import (
-	"encoding/xml"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math"
 	"net/http"
+	"os"
+	"path"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/time/rate"
 )
 
+// downloadAttempts is the number of times a tile is retrieved if the checksum doesn't match (or the server asks
+// to retry later)
+const downloadAttempts = 3
+
+// maxRetryAfter is the longest wait before retrying a download if the server asks to retry later
+const maxRetryAfter = time.Minute
+
 const (
-	SRTM_BASE_URL = "http://dds.cr.usgs.gov/srtm"
-	SRTM1_URL     = "/version2_1/SRTM1/"
-	SRTM3_URL     = "/version2_1/SRTM3/"
+	SRTM_BASE_URL     = "http://dds.cr.usgs.gov/srtm"
+	SRTM1_URL         = "/version2_1/SRTM1/"
+	SRTM3_URL         = "/version2_1/SRTM3/"
+	KURVIGER_BASE_URL = "http://srtm.kurviger.de"
 )
 
+// DefaultMirrors are the servers (directories with the SRTM1/ and SRTM3/ subdirectories) tried in this order
+var DefaultMirrors = []string{SRTM_BASE_URL + "/version2_1", KURVIGER_BASE_URL}
+
 type Srtm struct {
-	cache map[string]*SrtmFile
+	// lock guards the cache and the tiles in it
+	lock   sync.Mutex
+	cache  map[string]*SrtmFile
+	client *http.Client
+	// lastSrtmFile is the tile of the last query (in the cache), nil when tiles are removed from the cache
+	lastSrtmFile *SrtmFile
+	// tileLoads single-flight the loading of every tile, which is done without the lock (see loadSrtmFileUnlocked)
+	tileLoads keyedMutex
+
+	// srtmDataLock guards srtmData (which can be replaced by the index refresh)
+	srtmDataLock sync.RWMutex
+	srtmData     SrtmData
+	srtmDataSet  bool
+	storage      SrtmLocalStorage
+
+	limiter      *rate.Limiter
+	redirects    *redirectPolicy
+	memoryMap    bool
+	rangeBaseUrl string
+	littleEndian bool
+
+	stats    CacheStats
+	metrics  Metrics
+	logLevel LogLevel
 
-	srtmData SrtmData
-	storage  SrtmLocalStorage
+	maxLoadedTiles        int
+	missingTileAsSeaLevel bool
+	voidSearchLimit       int
+	interpolation         InterpolationMode
+	lineOfSightSpacing    float64
+	tileFileNamer         TileFileNamer
+	resolutionPreference  []SrtmResolution
+	profileInterpolation  *InterpolationMode
+	voidFill              *float64
+	voidFillTileMean      bool
+	elevationFloor        *float64
+	tileScheme            TileScheme
+	checksums             map[string]string
+	mirrors               []string
+	crawlTimeout          time.Duration
+	crawlConcurrency      int
+	crawlDepth            *int
+	crawlResolutions      []SrtmResolution
+	tileTTL               time.Duration
+	maxStorageBytes       int64
+	storageAccess         map[string]time.Time
+
+	refreshInterval time.Duration
+	refreshStop     chan struct{}
+	refreshDone     chan struct{}
+
+	prefetchCells int
+	prefetchQueue chan prefetchRequest
+	prefetchDone  chan struct{}
+	useCounter    uint64
+}
+
+func NewSrtm(client *http.Client, options ...SrtmOption) (*Srtm, error) {
+	return NewSrtmWithCustomCacheDir(client, "", options...)
 }
 
-func NewSrtm(client *http.Client) (*Srtm, error) {
-	return NewSrtmWithCustomCacheDir(client, "")
+func NewSrtmWithCustomStorage(client *http.Client, storage SrtmLocalStorage, options ...SrtmOption) (*Srtm, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	result := &Srtm{
+		cache:   make(map[string]*SrtmFile),
+		client:  client,
+		storage: storage,
+	}
+	for _, option := range options {
+		option(result)
+	}
+	if len(result.mirrors) == 0 {
+		result.mirrors = DefaultMirrors
+	}
+
+	ctx := context.Background()
+	if result.crawlTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, result.crawlTimeout)
+		defer cancel()
+	}
+	if !result.srtmDataSet {
+		srtmData, err := newSrtmDataWithCrawler(result.newCrawler(ctx), storage, result.mirrors...)
+		if err != nil {
+			return nil, err
+		}
+		result.srtmData = *srtmData
+	}
+
+	if result.refreshInterval > 0 {
+		result.startIndexRefresh()
+	}
+
+	return result, nil
 }
 
-func NewSrtmWithCustomStorage(client *http.Client, storage SrtmLocalStorage) (*Srtm, error) {
-	srtmData, err := newSrtmData(client, storage)
+func NewSrtmWithCustomCacheDir(client *http.Client, cacheDirectory string, options ...SrtmOption) (*Srtm, error) {
+	storage, err := newLocalFileSrtmStorage(cacheDirectory, optionsLogLevel(options))
 	if err != nil {
 		return nil, err
 	}
+	return NewSrtmWithCustomStorage(client, storage, options...)
+}
+
+// NewSrtmWithFallbackCacheDirs uses the first writable cache directory (or memory), see NewFallbackSrtmStorage
+func NewSrtmWithFallbackCacheDirs(client *http.Client, cacheDirectories []string, options ...SrtmOption) (*Srtm, error) {
+	return NewSrtmWithCustomStorage(client, newFallbackSrtmStorage(optionsLogLevel(options), cacheDirectories...), options...)
+}
+
+// optionsLogLevel returns the log level set by the options (see WithLogLevel), for what is logged before the Srtm
+// is created
+func optionsLogLevel(options []SrtmOption) LogLevel {
+	var srtm Srtm
+	for _, option := range options {
+		option(&srtm)
+	}
+	return srtm.logLevel
+}
+
+// GetElevation returns the elevation (NaN if unknown), using the client given to the constructor
+func (self *Srtm) GetElevation(latitude, longitude float64) (float64, error) {
+	return self.GetElevationWithClient(self.client, latitude, longitude)
+}
+
+// getSampledElevation is like GetElevation, but NaN for points out of the SRTM coverage (for functions sampling
+// whole areas, which can extend beyond it)
+func (self *Srtm) getSampledElevation(latitude, longitude float64) (float64, error) {
+	elevation, err := self.GetElevation(latitude, longitude)
+	if errors.Is(err, ErrNoCoverage) {
+		return math.NaN(), nil
+	}
+	return elevation, err
+}
+
+// GetElevationInt is like GetElevation, but rounded to whole meters. ok is false if the elevation is unknown (no
+// tile, a void which can't be interpolated, or an error).
+func (self *Srtm) GetElevationInt(latitude, longitude float64) (elevation int, ok bool) {
+	result, err := self.GetElevation(latitude, longitude)
+	if err != nil || math.IsNaN(result) {
+		return 0, false
+	}
+	return int(math.Round(result)), true
+}
+
+// GetInterpolatedElevation is like GetElevation, but always interpolated bilinearly between the samples around the
+// point (whatever the interpolation mode), so that the elevations of close points change smoothly instead of in
+// steps of one cell
+func (self *Srtm) GetInterpolatedElevation(latitude, longitude float64) (float64, error) {
+	details, err := self.getElevationDetails(context.Background(), self.client, latitude, longitude, InterpolationBilinear)
+	return details.Elevation, err
+}
+
+// GetElevationWithClient is like GetElevation, with a per-call client for tiles downloaded by this call
+func (self *Srtm) GetElevationWithClient(client *http.Client, latitude, longitude float64) (float64, error) {
+	details, err := self.getElevationDetails(context.Background(), client, latitude, longitude, self.interpolation)
+	return details.Elevation, err
+}
+
+// GetElevationContext is like GetElevation, but the download of the tile (including the wait before retrying, if the
+// server asks to retry later) stops with ctx.Err() when the context is done
+func (self *Srtm) GetElevationContext(ctx context.Context, latitude, longitude float64) (float64, error) {
+	details, err := self.getElevationDetails(ctx, self.client, latitude, longitude, self.interpolation)
+	return details.Elevation, err
+}
+
+// HasTile checks (in the SRTM files index, without downloading anything) if there is a tile covering the coordinate
+func (self *Srtm) HasTile(latitude, longitude float64) bool {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return false
+	}
+	self.srtmDataLock.RLock()
+	defer self.srtmDataLock.RUnlock()
+
+	name, _, _ := self.getSrtmFileNameAndCoordinates(normalizeCoordinates(latitude, longitude))
+	_, srtmUrl, _ := self.srtmData.getBestSrtmUrl(name, self.resolutionPreference)
+	return srtmUrl != nil
+}
+
+// GetSrtmUrl looks up the tile (for example "N45E013") with the resolution in the SRTM files index, see
+// SrtmData.GetSrtmUrl
+func (self *Srtm) GetSrtmUrl(srtmFileName string, resolution SrtmResolution) (string, *SrtmUrl) {
+	self.srtmDataLock.RLock()
+	defer self.srtmDataLock.RUnlock()
+
+	return self.srtmData.GetSrtmUrl(srtmFileName, resolution)
+}
+
+// SetSrtmData replaces the SRTM files index. Tiles already loaded (or retrieved) are kept, tiles which were not
+// in the old index are looked up in the new one.
+func (self *Srtm) SetSrtmData(srtmData *SrtmData) {
+	self.srtmDataLock.Lock()
+	self.srtmData = *srtmData
+	self.srtmDataLock.Unlock()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for name, srtmFile := range self.cache {
+		if !srtmFile.isValidSrtmFile {
+			delete(self.cache, name)
+		}
+	}
+	self.lastSrtmFile = nil
+}
+
+// AvailableTiles returns the sorted names of all the tiles in the SRTM files index
+func (self *Srtm) AvailableTiles() []string {
+	self.srtmDataLock.RLock()
+	defer self.srtmDataLock.RUnlock()
 
-	return &Srtm{
-		cache:    make(map[string]*SrtmFile),
-		storage:  storage,
-		srtmData: *srtmData,
-	}, nil
+	return self.srtmData.AvailableTiles()
 }
 
-func NewSrtmWithCustomCacheDir(client *http.Client, cacheDirectory string) (*Srtm, error) {
-	storage, err := NewLocalFileSrtmStorage(cacheDirectory)
+// ElevationDetails is the elevation with info about how it was found
+type ElevationDetails struct {
+	Elevation float64
+	// Interpolated is true if the point is in a void, and the elevation is interpolated from the samples around it
+	Interpolated bool
+	// MaxScanDistance is the distance (in cells) of the farthest sample used for the void interpolation. Values
+	// interpolated across large voids are less reliable.
+	MaxScanDistance int
+	// Filled is true if the point is in a void which can't be interpolated, and the elevation is the fill value
+	// (see WithVoidFill)
+	Filled bool
+	// Clamped is true if the elevation was below the floor set with WithElevationFloor, and is the floor
+	Clamped bool
+}
+
+// Reasons returned by GetElevationReason
+const (
+	// ReasonTileUnavailable is the reason for unknown elevations of points not covered by any (retrievable) tile
+	ReasonTileUnavailable = "tile-unavailable"
+	// ReasonVoidUninterpolable is the reason for unknown elevations of points in voids which can't be interpolated
+	ReasonVoidUninterpolable = "void-uninterpolable"
+)
+
+// GetElevationReason is like GetElevation, with the reason why the elevation is NaN (ReasonTileUnavailable or
+// ReasonVoidUninterpolable), or "" for known elevations
+func (self *Srtm) GetElevationReason(latitude, longitude float64) (float64, string, error) {
+	details, err := self.getElevationDetails(context.Background(), self.client, latitude, longitude, self.interpolation)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, ErrTileNotAvailable) {
+			return details.Elevation, ReasonTileUnavailable, err
+		}
+		return details.Elevation, "", err
+	}
+	if !math.IsNaN(details.Elevation) {
+		return details.Elevation, "", nil
 	}
-	return NewSrtmWithCustomStorage(client, storage)
+	if !self.HasTile(latitude, longitude) {
+		return details.Elevation, ReasonTileUnavailable, nil
+	}
+	return details.Elevation, ReasonVoidUninterpolable, nil
 }
 
-func (self *Srtm) GetElevation(client *http.Client, latitude, longitude float64) (float64, error) {
+// GetElevationDetails is like GetElevation, but with info about the void interpolation
+func (self *Srtm) GetElevationDetails(latitude, longitude float64) (ElevationDetails, error) {
+	return self.getElevationDetails(context.Background(), self.client, latitude, longitude, self.interpolation)
+}
+
+func (self *Srtm) getElevationDetails(ctx context.Context, client *http.Client, latitude, longitude float64, mode InterpolationMode) (ElevationDetails, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return ElevationDetails{Elevation: math.NaN()}, err
+	}
+	latitude, longitude = normalizeCoordinates(latitude, longitude)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile := self.getSrtmFileForPoint(latitude, longitude)
+
+	if !srtmFile.isValidSrtmFile && !isInSrtmCoverage(latitude) {
+		return ElevationDetails{Elevation: math.NaN()}, fmt.Errorf("%w: %v,%v", ErrNoCoverage, latitude, longitude)
+	}
+	if !srtmFile.isValidSrtmFile && self.missingTileAsSeaLevel {
+		return ElevationDetails{Elevation: 0}, nil
+	}
+
+	self.useCounter++
+	srtmFile.lastUsed = self.useCounter
+
+	if srtmFile.isValidSrtmFile {
+		if srtmFile.isLoaded() {
+			self.stats.Hits++
+			metricsOrNoop(self.metrics).CacheHit(srtmFile.name)
+		} else {
+			self.stats.Misses++
+			metricsOrNoop(self.metrics).CacheMiss(srtmFile.name)
+		}
+	}
+	// Tiles read with range requests are loaded row by row (the lock is released during the requests):
+	if srtmFile.isValidSrtmFile && len(srtmFile.contents) == 0 && len(srtmFile.rangeUrl) > 0 {
+		if err := self.readRangeRequests(ctx, self.httpClient(client), srtmFile, latitude, longitude); err != nil {
+			return ElevationDetails{Elevation: math.NaN()}, err
+		}
+	}
+	if srtmFile.isValidSrtmFile && !srtmFile.isLoaded() && len(srtmFile.rangeUrl) == 0 {
+		if err := self.loadSrtmFileUnlocked(ctx, self.httpClient(client), srtmFile, true); err != nil {
+			return ElevationDetails{Elevation: math.NaN()}, err
+		}
+	}
+	downloads, loaded := srtmFile.downloads, len(srtmFile.contents) > 0
+
+	elevation, err := srtmFile.getElevation(ctx, self.httpClient(client), self.storage, latitude, longitude)
+	details := ElevationDetails{Elevation: elevation}
+	self.stats.Downloads += srtmFile.downloads - downloads
+	if !loaded && len(srtmFile.contents) > 0 {
+		self.tileLoaded(srtmFile)
+	}
+	if err == nil && len(srtmFile.contents) > 0 {
+		// The neighbouring tiles are loaded with the lock released:
+		unpin := srtmFile.pin()
+		details = self.interpolate(self.httpClient(client), srtmFile, latitude, longitude, elevation, mode)
+		unpin()
+	}
+	if err == nil && srtmFile.isValidSrtmFile && math.IsNaN(details.Elevation) {
+		details = self.fillVoid(srtmFile, details)
+	}
+	if self.elevationFloor != nil && details.Elevation < *self.elevationFloor {
+		details = ElevationDetails{Elevation: *self.elevationFloor, Clamped: true}
+	}
+	if self.maxLoadedTiles > 0 {
+		self.unloadLeastRecentlyUsed(self.maxLoadedTiles)
+	}
+	if err == nil && self.prefetchCells > 0 {
+		self.prefetchNeighbours(srtmFile, latitude, longitude)
+	}
+
+	return details, err
+}
+
+// getSrtmFileForPoint returns the (cached) tile of the (normalized) point, must be called with the lock held.
+// Consecutive queries are usually in the same tile (tracks, grids), so the tile of the last query is checked first,
+// without formatting the tile name and looking it up in the cache.
+func (self *Srtm) getSrtmFileForPoint(latitude, longitude float64) *SrtmFile {
+	// Only the default tile scheme is known to have one degree tiles:
+	if last := self.lastSrtmFile; last != nil && self.tileScheme == nil && last.containsPoint(latitude, longitude) {
+		return last
+	}
+
 	srtmFileName, srtmLatitude, srtmLongitude := self.getSrtmFileNameAndCoordinates(latitude, longitude)
-	//log.Printf("srtmFileName for %v,%v: %s", latitude, longitude, srtmFileName)
+	self.lastSrtmFile = self.getSrtmFile(srtmFileName, srtmLatitude, srtmLongitude)
+	return self.lastSrtmFile
+}
 
+// getSrtmFile returns the (cached) tile, must be called with the lock held
+func (self *Srtm) getSrtmFile(srtmFileName string, srtmLatitude, srtmLongitude float64) *SrtmFile {
 	srtmFile, ok := self.cache[srtmFileName]
 	if !ok {
-		srtmFile = newSrtmFile(srtmFileName, "", srtmLatitude, srtmLongitude)
-		baseUrl, srtmFileUrl := self.srtmData.GetBestSrtmUrl(srtmFileName)
+		srtmFile = newSrtmFile(srtmFileName, "", 0, srtmLatitude, srtmLongitude)
+		self.srtmDataLock.RLock()
+		baseUrl, srtmFileUrl, resolution := self.srtmData.getBestSrtmUrl(srtmFileName, self.resolutionPreference)
+		self.srtmDataLock.RUnlock()
 		if srtmFileUrl != nil {
-			srtmFile = newSrtmFile(srtmFileName, baseUrl+srtmFileUrl.Url, srtmLatitude, srtmLongitude)
+			srtmFile = newSrtmFile(srtmFileName, baseUrl+srtmFileUrl.Url, resolution, srtmLatitude, srtmLongitude)
+			srtmFile.sha256 = srtmFileUrl.Sha256
+			if checksum, ok := self.checksums[srtmFileName]; ok {
+				srtmFile.sha256 = checksum
+			}
+			srtmFile.mirrorUrls = self.getMirrorUrls(srtmFile.fileUrl)
+			if self.tileFileNamer != nil {
+				srtmFile.storageName = self.tileFileNamer(srtmFileName, resolution)
+			}
+			srtmFile.ttl = self.tileTTL
+		}
+		srtmFile.memoryMap = self.memoryMap
+		srtmFile.metrics = self.metrics
+		srtmFile.logLevel = self.logLevel
+		srtmFile.littleEndian = self.littleEndian
+		srtmFile.scheme = self.tileScheme
+		if len(self.rangeBaseUrl) > 0 && srtmFile.isValidSrtmFile {
+			srtmFile.rangeUrl = fmt.Sprintf("%s%s.hgt", self.rangeBaseUrl, srtmFileName)
 		}
 		self.cache[srtmFileName] = srtmFile
 	}
+	return srtmFile
+}
 
-	return srtmFile.getElevation(client, self.storage, latitude, longitude)
+// loadSrtmFile loads the contents of the tile (from the storage or server), must be called with the lock held
+func (self *Srtm) loadSrtmFile(client *http.Client, srtmFile *SrtmFile) error {
+	downloads := srtmFile.downloads
+	err := srtmFile.loadContents(context.Background(), client, self.storage)
+	self.stats.Downloads += srtmFile.downloads - downloads
+	if err == nil && len(srtmFile.contents) > 0 {
+		self.tileLoaded(srtmFile)
+	}
+	return err
 }
 
-func (self *Srtm) getSrtmFileNameAndCoordinates(latitude, longitude float64) (string, float64, float64) {
-	northSouth := 'S'
-	if latitude >= 0 {
-		northSouth = 'N'
+// loadSrtmFileUnlocked is like loadSrtmFile, but the lock is released while the tile is read from the storage or
+// server, so that the queries of other (loaded) tiles don't wait for the download. Loads of the same tile wait for
+// each other. If waitRetryAfter, the download waits (until ctx is done) when the server asks to retry later. Must be
+// called with the lock held.
+func (self *Srtm) loadSrtmFileUnlocked(ctx context.Context, client *http.Client, srtmFile *SrtmFile, waitRetryAfter bool) error {
+	self.lock.Unlock()
+	unlockTile := self.tileLoads.Lock(srtmFile.name)
+	self.lock.Lock()
+	defer unlockTile()
+
+	// Loaded while waiting (or removed from the cache, then the caller loads it with the lock held):
+	if srtmFile.isLoaded() || self.cache[srtmFile.name] != srtmFile {
+		return nil
 	}
 
-	eastWest := 'W'
-	if longitude >= 0 {
-		eastWest = 'E'
+	// Loaded into a copy, the tile itself can be read (and unloaded) while the lock is released:
+	loading := *srtmFile
+	loading.waitRetryAfter = waitRetryAfter
+	loading.pins, loading.unloadPending = 0, false
+	self.lock.Unlock()
+	err := loading.loadContents(ctx, client, self.storage)
+	self.lock.Lock()
+
+	self.stats.Downloads += loading.downloads - srtmFile.downloads
+	srtmFile.downloads = loading.downloads
+	if err != nil {
+		return err
+	}
+	if srtmFile.isLoaded() || self.cache[srtmFile.name] != srtmFile {
+		loading.unload()
+		return nil
+	}
+	srtmFile.contents, srtmFile.squareSize, srtmFile.mapped = loading.contents, loading.squareSize, loading.mapped
+	if len(srtmFile.contents) > 0 {
+		self.tileLoaded(srtmFile)
+	}
+	return nil
+}
+
+// getMirrorUrls finds the same file on the other mirrors (if the url is from one of the mirrors)
+func (self *Srtm) getMirrorUrls(fileUrl string) []string {
+	for _, mirror := range self.mirrors {
+		if !strings.HasPrefix(fileUrl, mirror) {
+			continue
+		}
+		result := []string{}
+		for _, otherMirror := range self.mirrors {
+			if otherMirror != mirror {
+				result = append(result, otherMirror+strings.TrimPrefix(fileUrl, mirror))
+			}
+		}
+		return result
 	}
+	return nil
+}
 
-	latPart := int(math.Abs(math.Floor(latitude)))
-	lonPart := int(math.Abs(math.Floor(longitude)))
+// Close stops background workers and releases all the resources held in memory (decompressed and memory mapped
+// tiles). The Srtm can still be
+// used after Close, tiles will be loaded again from the storage.
+func (self *Srtm) Close() error {
+	self.stopPrefetch()
+	self.stopIndexRefresh()
 
-	srtmFileName := fmt.Sprintf("%s%02d%s%03d", string(northSouth), latPart, string(eastWest), lonPart)
+	self.lock.Lock()
+	defer self.lock.Unlock()
 
-	return srtmFileName, math.Floor(latitude), math.Floor(longitude)
+	for _, srtmFile := range self.cache {
+		srtmFile.unload()
+	}
+	self.cache = make(map[string]*SrtmFile)
+	self.lastSrtmFile = nil
+
+	return nil
+}
+
+// CacheStats contains counters about the tiles in memory and downloads
+type CacheStats struct {
+	// Tiles is the number of tiles currently in memory
+	Tiles int
+	// Bytes held by the tiles in memory
+	Bytes int
+	// Hits is the number of queries served from tiles already in memory
+	Hits int
+	// Misses is the number of queries which needed to load a tile (from the storage or server)
+	Misses int
+	// Downloads is the number of requests for tile data
+	Downloads int
+}
+
+func (self *Srtm) CacheStats() CacheStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	stats := self.stats
+	for _, srtmFile := range self.cache {
+		if srtmFile.isLoaded() {
+			stats.Tiles++
+			stats.Bytes += srtmFile.loadedBytes()
+		}
+	}
+
+	return stats
+}
+
+// ClearCache removes the contents of all tiles from memory (but keeps the info about them). Tiles are loaded
+// again from the storage when needed. Returns the number of bytes freed.
+func (self *Srtm) ClearCache() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	freed := 0
+	for _, srtmFile := range self.cache {
+		freed += srtmFile.unload()
+	}
+	self.logLevel.logf(LogInfo, "Cleared %d bytes from cache", freed)
+
+	return freed
+}
+
+// UnloadTile removes the decompressed contents of a tile from memory (the file in the storage is kept, and
+// reloaded when needed). Returns false if the tile wasn't loaded.
+func (self *Srtm) UnloadTile(srtmFileName string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	srtmFile, found := self.cache[srtmFileName]
+	if !found || !srtmFile.isLoaded() {
+		return false
+	}
+	srtmFile.unload()
+	return true
+}
+
+// PurgeStorage deletes the files of the tiles (for example "N45E013") from the storage and from memory, so that
+// they are downloaded again when needed
+func (self *Srtm) PurgeStorage(srtmFileNames ...string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, srtmFileName := range srtmFileNames {
+		if srtmFile, found := self.cache[srtmFileName]; found {
+			srtmFile.unload()
+			delete(self.cache, srtmFileName)
+			self.lastSrtmFile = nil
+		}
+		for _, storageName := range self.storageNames(srtmFileName) {
+			for _, extension := range append(compressedExtensions(), "") {
+				if err := self.storage.Delete(storageName + ".hgt" + extension); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// storageNames returns the names (without the extension) the tile can be cached with in the storage
+func (self *Srtm) storageNames(srtmFileName string) []string {
+	if self.tileFileNamer == nil {
+		return []string{srtmFileName}
+	}
+	result := []string{}
+	for _, resolution := range []SrtmResolution{SRTM1, SRTM3} {
+		storageName := self.tileFileNamer(srtmFileName, resolution)
+		if len(result) == 0 || result[len(result)-1] != storageName {
+			result = append(result, storageName)
+		}
+	}
+	return result
+}
+
+// PurgeAllStorage is like PurgeStorage, for all the tiles in the SRTM files index (and in memory)
+func (self *Srtm) PurgeAllStorage() error {
+	srtmFileNames := self.AvailableTiles()
+	self.lock.Lock()
+	for srtmFileName := range self.cache {
+		srtmFileNames = append(srtmFileNames, srtmFileName)
+	}
+	self.lock.Unlock()
+
+	return self.PurgeStorage(srtmFileNames...)
+}
+
+// EvictTile is like UnloadTile, for the tile covering the coordinate
+func (self *Srtm) EvictTile(latitude, longitude float64) bool {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return false
+	}
+	name, _, _ := self.getSrtmFileNameAndCoordinates(normalizeCoordinates(latitude, longitude))
+	return self.UnloadTile(name)
+}
+
+// unloadLeastRecentlyUsed unloads tiles until no more than maxLoaded are in memory
+func (self *Srtm) unloadLeastRecentlyUsed(maxLoaded int) {
+	for {
+		loaded := 0
+		var leastRecentlyUsed *SrtmFile
+		for _, srtmFile := range self.cache {
+			if !srtmFile.isLoaded() {
+				continue
+			}
+			loaded++
+			// Tiles being read are kept:
+			if srtmFile.pins == 0 && (leastRecentlyUsed == nil || srtmFile.lastUsed < leastRecentlyUsed.lastUsed) {
+				leastRecentlyUsed = srtmFile
+			}
+		}
+		if loaded <= maxLoaded || leastRecentlyUsed == nil {
+			return
+		}
+		self.logLevel.logf(LogDebug, "Unloading %s", leastRecentlyUsed.name)
+		leastRecentlyUsed.unload()
+	}
+}
+
+// httpClient returns the client to be used for outgoing requests (with the rate limiter and the redirect policy, if
+// configured)
+func (self *Srtm) httpClient(client *http.Client) *http.Client {
+	if self.limiter == nil && self.redirects == nil {
+		return client
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	configured := *client
+	if self.limiter != nil {
+		configured.Transport = &rateLimitedTransport{limiter: self.limiter, transport: client.Transport}
+	}
+	if self.redirects != nil {
+		configured.CheckRedirect = self.redirects.checkRedirect
+	}
+	return &configured
+}
+
+func (self *Srtm) getSrtmFileNameAndCoordinates(latitude, longitude float64) (string, float64, float64) {
+	// Can be called on a nil Srtm (the coordinate mapping doesn't need anything else):
+	if self != nil && self.tileScheme != nil {
+		return self.tileScheme.Tile(latitude, longitude)
+	}
+	return getTileNameAndCoordinates(latitude, longitude)
 }
 
 // Struct with contents and some utility methods of a single SRTM file
@@ -92,96 +678,553 @@ type SrtmFile struct {
 	contents            []byte
 	name                string
 	fileUrl             string
+	resolution          SrtmResolution
 	isValidSrtmFile     bool
 	fileRetrieved       bool
 	squareSize          int
+
+	// memoryMap is true if contents should be memory mapped from an unzipped file
+	memoryMap bool
+	// mapped is true if contents are currently memory mapped
+	mapped bool
+
+	// rangeUrl is the url of the uncompressed tile if only the needed rows are retrieved (with range requests)
+	rangeUrl string
+	// rows retrieved with range requests
+	rows map[int][]byte
+
+	// lastUsed is used to find the least recently used tiles
+	lastUsed uint64
+	// downloads is the number of requests for this tile's data
+	downloads int
+	// sha256 (hex) of the downloaded file, not verified if empty
+	sha256 string
+	// mirrorUrls are tried (in this order) if the server of fileUrl is offline
+	mirrorUrls []string
+	// ttl is the maximum age of the cached file, 0 if cached files never expire
+	ttl time.Duration
+	// storageName is the name of the cached file (without the extension) in the storage
+	storageName string
+	// scheme maps coordinates to rows and columns, SrtmTileScheme if nil
+	scheme TileScheme
+	// mean elevation of the valid samples (NaN if not computed yet)
+	mean float64
+	// littleEndian is true if the samples are little-endian (instead of the standard big-endian)
+	littleEndian bool
+	// metrics receives the download events, NoopMetrics if nil
+	metrics Metrics
+	// waitRetryAfter is true if the download can wait when the server asks to retry later, only when loaded without
+	// the lock (see loadSrtmFileUnlocked), so that the wait doesn't block the other queries
+	waitRetryAfter bool
+	// logLevel is the minimum level of the logged messages
+	logLevel LogLevel
+	// pins is the number of readers of the tile which can release the lock (to load the neighbouring tiles), the
+	// tile is unloaded only when the last one is done (if unloadPending), see pin
+	pins          int
+	unloadPending bool
 }
 
-func newSrtmFile(name, fileUrl string, latitude, longitude float64) *SrtmFile {
+func newSrtmFile(name, fileUrl string, resolution SrtmResolution, latitude, longitude float64) *SrtmFile {
 	result := SrtmFile{}
 	result.name = name
+	result.storageName = name
+	result.mean = math.NaN()
+	result.resolution = resolution
 	result.isValidSrtmFile = len(fileUrl) > 0
 	result.latitude = latitude
 	result.longitude = longitude
 
 	result.fileUrl = fileUrl
-	if !strings.HasSuffix(result.fileUrl, ".zip") {
+	if compressedExtension(result.fileUrl) == "" {
 		result.fileUrl += ".zip"
 	}
 
 	return &result
 }
 
-func (self *SrtmFile) loadContents(client *http.Client, storage SrtmLocalStorage) error {
+func (self *SrtmFile) loadContents(ctx context.Context, client *http.Client, storage SrtmLocalStorage) error {
 	if !self.isValidSrtmFile || len(self.fileUrl) == 0 {
 		return nil
 	}
 
-	fileName := fmt.Sprintf("%s.hgt.zip", self.name)
+	if self.memoryMap {
+		if pathStorage, ok := storage.(SrtmLocalFilePathStorage); ok {
+			err := self.loadMappedContents(ctx, client, pathStorage)
+			if err == nil {
+				return nil
+			}
+			self.logLevel.logf(LogWarn, "Error memory mapping %s (%s) => loading into memory", self.name, err.Error())
+			if len(self.contents) > 0 {
+				return nil
+			}
+		}
+	}
+
+	return self.loadZippedContents(ctx, client, storage)
+}
 
-	bytes, err := storage.LoadFile(fileName)
+// loadMappedContents memory maps the unzipped .hgt file (unzipping it into the storage first, if needed)
+func (self *SrtmFile) loadMappedContents(ctx context.Context, client *http.Client, storage SrtmLocalFilePathStorage) error {
+	fileName := fmt.Sprintf("%s.hgt", self.storageName)
+	filePath := storage.FilePath(fileName)
+	defer lockStorageFile(storage, fileName)()
+
+	// The unzipped file is as old as the cached tile:
+	if self.isExpired(storage, fileName) {
+		self.logLevel.logf(LogInfo, "Unzipped file %s expired", fileName)
+		if err := storage.Delete(fileName); err != nil {
+			return err
+		}
+	}
+
+	contents, err := mmapFile(filePath)
+	if err == nil {
+		err = self.setMappedContents(contents)
+		if err == nil {
+			return nil
+		}
+		self.logLevel.logf(LogWarn, "Invalid unzipped file %s (%s) => unzipping again", fileName, err.Error())
+		if err := storage.Delete(fileName); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(self.contents) == 0 {
+		if err := self.loadZippedContents(ctx, client, storage); err != nil {
+			return err
+		}
+	}
+	if err := storage.SaveFile(fileName, self.contents); err != nil {
+		return err
+	}
+
+	contents, err = mmapFile(filePath)
 	if err != nil {
-		if storage.IsNotExists(err) {
-			log.Printf("File %s not retrieved => retrieving: %s", fileName, self.fileUrl)
-			req, err := http.NewRequest(http.MethodGet, self.fileUrl, nil)
-			if err != nil {
-				return err
+		return err
+	}
+	return self.setMappedContents(contents)
+}
+
+func (self *SrtmFile) setMappedContents(contents []byte) error {
+	squareSize, err := self.validateContents(contents)
+	if err != nil {
+		_ = munmapFile(contents)
+		return err
+	}
+
+	self.contents = contents
+	self.squareSize = squareSize
+	self.mapped = true
+	self.logLevel.logf(LogDebug, "Memory mapped %dbytes of %s, squareSize=%d", len(self.contents), self.name, self.squareSize)
+
+	return nil
+}
+
+func (self *SrtmFile) loadZippedContents(ctx context.Context, client *http.Client, storage SrtmLocalStorage) error {
+	// Locked by name without the extension, both .hgt.zip and .hgt.gz are written (and deleted) here:
+	defer lockStorageFile(storage, self.storageName+".hgt.*")()
+
+	var expiredFileName string
+	// Local tiles can be gzipped (or compressed with a registered Decompressor) instead of zipped:
+	for _, extension := range compressedExtensions() {
+		fileName := self.storageName + ".hgt" + extension
+		if self.isExpired(storage, fileName) {
+			self.logLevel.logf(LogInfo, "Cached file %s expired => retrieving again: %s", fileName, self.fileUrl)
+			expiredFileName = fileName
+			continue
+		}
+		reader, err := openStorageFile(storage, fileName)
+		if err == nil {
+			err = self.setContentsFromReader(reader)
+			_ = reader.Close()
+			if err == nil {
+				self.logLevel.logf(LogDebug, "Loaded %dbytes from %s, squareSize=%d", len(self.contents), fileName, self.squareSize)
+				return nil
 			}
-			response, err := client.Do(req)
-			if err != nil {
-				log.Printf("Error retrieving file: %s", err.Error())
+			self.logLevel.logf(LogWarn, "Invalid cached file %s (%s) => retrieving again: %s", fileName, err.Error(), self.fileUrl)
+			if err := storage.Delete(fileName); err != nil {
 				return err
 			}
+		} else if !storage.IsNotExists(err) {
+			return err
+		}
+	}
+	self.logLevel.logf(LogInfo, "File %s not retrieved => retrieving: %s", self.name, self.fileUrl)
 
-			responseBytes, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				return err
+	var bytes []byte
+	for attempt := 1; ; attempt++ {
+		var err error
+		bytes, err = self.download(ctx, client)
+		if err == nil {
+			if err = self.verifyChecksum(bytes); err == nil {
+				break
+			}
+			self.logLevel.logf(LogWarn, "%s (attempt %d of %d)", err.Error(), attempt, downloadAttempts)
+		}
+		if err != nil && (bytes == nil || attempt >= downloadAttempts) {
+			// Better an expired tile than none:
+			if len(expiredFileName) > 0 && self.loadExpiredContents(storage, expiredFileName) == nil {
+				self.logLevel.logf(LogWarn, "Error retrieving %s (%s) => using the expired %s", self.fileUrl, err.Error(), expiredFileName)
+				return nil
 			}
-			_ = response.Body.Close()
+			return err
+		}
+	}
 
-			if err := storage.SaveFile(fileName, responseBytes); err != nil {
-				return err
+	fileName := fmt.Sprintf("%s.hgt.zip", self.storageName)
+	if isGzipBytes(bytes) {
+		fileName = fmt.Sprintf("%s.hgt.gz", self.storageName)
+	} else if extension := compressedExtension(self.fileUrl); !isZipBytes(bytes) && len(extension) > 0 {
+		fileName = self.storageName + ".hgt" + extension
+	}
+
+	// Validate before saving, a corrupt download must never end up in the cache:
+	if err := self.setContents(bytes); err != nil {
+		self.logLevel.logf(LogError, "Error loading file %s: %s", fileName, err.Error())
+		return err
+	}
+
+	if err := storage.SaveFile(fileName, bytes); err != nil {
+		return err
+	}
+	self.logLevel.logf(LogDebug, "Written %d bytes to %s", len(bytes), fileName)
+	if len(expiredFileName) > 0 && expiredFileName != fileName {
+		if err := storage.Delete(expiredFileName); err != nil {
+			return err
+		}
+	}
+
+	self.logLevel.logf(LogDebug, "Loaded %dbytes from %s, squareSize=%d", len(self.contents), fileName, self.squareSize)
+
+	return nil
+}
+
+func (self *SrtmFile) loadExpiredContents(storage SrtmLocalStorage, fileName string) error {
+	reader, err := openStorageFile(storage, fileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return self.setContentsFromReader(reader)
+}
+
+// isExpired checks if the file in the storage is older than the TTL (if the storage knows when files were saved)
+func (self *SrtmFile) isExpired(storage SrtmLocalStorage, fileName string) bool {
+	if self.ttl <= 0 {
+		return false
+	}
+	modTimeStorage, ok := storage.(SrtmModTimeStorage)
+	if !ok {
+		return false
+	}
+	modTime, err := modTimeStorage.ModTime(fileName)
+	if err != nil {
+		return false
+	}
+	return time.Since(modTime) > self.ttl
+}
+
+// download retrieves the file from its server (or the mirrors). If the server asks to retry later, waits (unless
+// waitRetryAfter is false) until then or until the context is done.
+func (self *SrtmFile) download(ctx context.Context, client *http.Client) ([]byte, error) {
+	var bytes []byte
+	var err error
+	for n, fileUrl := range append([]string{self.fileUrl}, self.mirrorUrls...) {
+		var failover bool
+		for attempt := 1; ; attempt++ {
+			bytes, err, failover = self.downloadFrom(ctx, client, fileUrl)
+			// The server asked to try again later:
+			var retryAfter *retryAfterError
+			if !self.waitRetryAfter || !errors.As(err, &retryAfter) || attempt >= downloadAttempts || retryAfter.wait > maxRetryAfter {
+				break
 			}
-			log.Printf("Written %d bytes to %s", len(responseBytes), fileName)
+			self.logLevel.logf(LogWarn, "%s => retrying in %s", err.Error(), retryAfter.wait)
+			select {
+			case <-time.After(retryAfter.wait):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w: waiting to retry %s", ctx.Err(), fileUrl)
+			}
+		}
+		if err == nil || !failover {
+			return bytes, err
+		}
+		if n < len(self.mirrorUrls) {
+			self.logLevel.logf(LogWarn, "%s => trying the next mirror", err.Error())
+		}
+	}
+	return bytes, err
+}
+
+// downloadFrom retrieves the file from a single server, failover is true if the server is offline (or failing)
+func (self *SrtmFile) downloadFrom(ctx context.Context, client *http.Client, fileUrl string) (result []byte, err error, failover bool) {
+	self.downloads++
 
-			bytes = responseBytes
+	start := time.Now()
+	metrics := metricsOrNoop(self.metrics)
+	metrics.DownloadStarted(fileUrl)
+	defer func() {
+		if err != nil {
+			metrics.DownloadFailed(fileUrl, err, time.Since(start))
 		} else {
+			metrics.DownloadCompleted(fileUrl, len(result), time.Since(start))
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return nil, err, false
+	}
+	// Otherwise the transport would decode .hgt.gz files served with "Content-Encoding: gzip":
+	req.Header.Set("Accept-Encoding", "identity")
+	response, err := client.Do(req)
+	if err != nil {
+		self.logLevel.logf(LogWarn, "Error retrieving file: %s", err.Error())
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: error retrieving %s", ctx.Err(), fileUrl), false
+		}
+		if redirectErr := asRedirectError(err); redirectErr != nil {
+			return nil, fmt.Errorf("%w: error retrieving %s", redirectErr, fileUrl), true
+		}
+		return nil, fmt.Errorf("%w: error retrieving %s: %s", ErrOffline, fileUrl, err.Error()), true
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%w: %s returned %s", ErrTileNotAvailable, fileUrl, response.Status)
+		if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+			if wait, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+				err = &retryAfterError{err: err, wait: wait}
+			}
+		}
+		return nil, err, response.StatusCode >= 500
+	}
+
+	// Only the compressed file is kept in memory (to be saved), setContents unzips directly from it:
+	bytes, err := readResponseBody(response)
+	if err != nil {
+		return nil, err, true
+	}
+	// Encoded anyway, but a .hgt.gz (served with "Content-Encoding: gzip") is a tile as it is:
+	if isGzipEncoded(response) {
+		if decoded, err := ungzipBytes(&bytes); err == nil && isCompressedTileBytes(fileUrl, *decoded) {
+			bytes = *decoded
+		}
+	}
+
+	// Some servers respond with 200 and an error page, that must not be cached as a tile:
+	if !isCompressedTileBytes(fileUrl, bytes) {
+		if isHtmlBytes(bytes) {
+			return nil, fmt.Errorf("%w: retrieved HTML page instead of a zip file from %s", ErrCorruptTile, fileUrl), false
+		}
+		return nil, fmt.Errorf("%w: retrieved invalid zip file from %s, starts with %s", ErrCorruptTile, fileUrl, bytesPrefix(bytes)), false
+	}
+
+	return bytes, nil, false
+}
+
+// verifyChecksum checks the downloaded file against the known checksum, if any
+func (self *SrtmFile) verifyChecksum(bytes []byte) error {
+	if len(self.sha256) == 0 {
+		return nil
+	}
+	checksum := sha256.Sum256(bytes)
+	if actual := hex.EncodeToString(checksum[:]); !strings.EqualFold(actual, self.sha256) {
+		return fmt.Errorf("%w: invalid checksum of %s: %s, expected %s", ErrCorruptTile, self.fileUrl, actual, self.sha256)
+	}
+	return nil
+}
+
+// setContentsFromReader is like setContents, but files (from a SrtmStreamingStorage) are unzipped without loading
+// the whole zipped file into memory
+func (self *SrtmFile) setContentsFromReader(reader io.Reader) error {
+	if file, ok := reader.(*os.File); ok {
+		stat, err := file.Stat()
+		if err != nil {
 			return err
 		}
+		magic := make([]byte, len(zipMagic))
+		_, err = file.ReadAt(magic, 0)
+		if _, ok := getDecompressor(".gz").(GzipDecompressor); ok && err == nil && isGzipBytes(magic) {
+			contents, err := ungzipReader(file, gzipSizeHint(file, stat.Size()))
+			if err != nil {
+				return fmt.Errorf("%w: error ungzipping %s: %s", ErrCorruptTile, self.name, err.Error())
+			}
+			return self.setUnzippedContents(contents)
+		}
+		if _, ok := getDecompressor(".zip").(ZipDecompressor); ok && err == nil && isZipBytes(magic) {
+			if err := self.checkZipHgtName(file, stat.Size()); err != nil {
+				return err
+			}
+			contents, err := unzipReaderAt(file, stat.Size())
+			if err != nil {
+				return fmt.Errorf("%w: error unzipping %s: %s", ErrCorruptTile, self.name, err.Error())
+			}
+			return self.setUnzippedContents(contents)
+		}
 	}
 
-	contents, err := unzipBytes(bytes)
+	zipped, err := ioutil.ReadAll(reader)
 	if err != nil {
-		log.Printf("Error loading file %s: %s", fileName, err.Error())
+		return err
 	}
-	self.contents = contents
+	return self.setContents(zipped)
+}
 
-	log.Printf("Loaded %dbytes from %s, squareSize=%d", len(self.contents), fileName, self.squareSize)
+// setContents decompresses the file bytes (see decompressTile) and validates the tile size
+func (self *SrtmFile) setContents(zipped []byte) error {
+	if isZipBytes(zipped) {
+		if err := self.checkZipHgtName(bytes.NewReader(zipped), int64(len(zipped))); err != nil {
+			return err
+		}
+	}
+	contents, err := decompressTile(self.fileUrl, zipped)
+	if err != nil {
+		return fmt.Errorf("%w: error unzipping %s: %s", ErrCorruptTile, self.name, err.Error())
+	}
+	return self.setUnzippedContents(contents)
+}
+
+// checkZipHgtName checks that the .hgt file in the .zip archive is this tile (for example N45E013.hgt, in any
+// directory), so that a mislabeled archive isn't used for the wrong region. Files not named like a tile are accepted.
+func (self *SrtmFile) checkZipHgtName(reader io.ReaderAt, size int64) error {
+	hgt, err := findZipHgt(reader, size)
+	if err != nil {
+		return fmt.Errorf("%w: error unzipping %s: %s", ErrCorruptTile, self.name, err.Error())
+	}
+	hgtName := strings.TrimSuffix(path.Base(hgt.Name), path.Ext(hgt.Name))
+	if _, _, _, _, ok := TileBounds(hgtName); ok && !strings.EqualFold(hgtName, self.name) {
+		return fmt.Errorf("%w: %s contains %s instead of %s.hgt", ErrCorruptTile, self.fileUrl, hgt.Name, self.name)
+	}
+	return nil
+}
+
+func (self *SrtmFile) setUnzippedContents(contents []byte) error {
+	squareSize, err := self.validateContents(contents)
+	if err != nil {
+		return err
+	}
+
+	self.contents = contents
+	self.squareSize = squareSize
 
 	return nil
 }
 
-func (self *SrtmFile) getElevation(client *http.Client, storage SrtmLocalStorage, latitude, longitude float64) (float64, error) {
+func (self *SrtmFile) validateContents(contents []byte) (int, error) {
+	return self.getSquareSize(len(contents))
+}
+
+// getSquareSize finds the square size for a tile of byteCount bytes
+func (self *SrtmFile) getSquareSize(byteCount int) (int, error) {
+	squareSizeFloat := math.Sqrt(float64(byteCount) / 2.0)
+	squareSize := int(squareSizeFloat)
+
+	if squareSizeFloat != float64(squareSize) || squareSize <= 0 {
+		return 0, fmt.Errorf("%w: invalid size for file %s: %d", ErrCorruptTile, self.name, byteCount)
+	}
+	// A truncated file can still be a perfect square, so check the size expected for the resolution:
+	if expected := self.resolution.SquareSize(); expected > 0 && squareSize != expected {
+		return 0, fmt.Errorf("%w: invalid square size for file %s: %d, expected %d", ErrCorruptTile, self.name, squareSize, expected)
+	}
+
+	return squareSize, nil
+}
+
+// String describes the tile, for debugging
+func (self *SrtmFile) String() string {
+	state := "not loaded"
+	if self.mapped {
+		state = "memory mapped"
+	} else if self.isLoaded() {
+		state = "loaded"
+	} else if !self.isValidSrtmFile {
+		state = "not available"
+	}
+	return fmt.Sprintf("%s (%v,%v - %v,%v, squareSize=%d, %s, %d bytes)", self.name, self.latitude, self.longitude,
+		self.latitude+1, self.longitude+1, self.squareSize, state, self.loadedBytes())
+}
+
+// containsPoint checks if the (normalized) point is in the one degree tile, see getTileNameAndCoordinates
+func (self *SrtmFile) containsPoint(latitude, longitude float64) bool {
+	if latitude >= 90 {
+		latitude = 89
+	}
+	return latitude >= self.latitude && latitude < self.latitude+1 && longitude >= self.longitude && longitude < self.longitude+1
+}
+
+func (self *SrtmFile) isLoaded() bool {
+	return len(self.contents) > 0 || len(self.rows) > 0
+}
+
+func (self *SrtmFile) loadedBytes() int {
+	result := len(self.contents)
+	for _, row := range self.rows {
+		result += len(row)
+	}
+	return result
+}
+
+// unload removes contents from memory, returns the number of bytes freed
+// pin keeps the contents of the tile while it's read, unloads are postponed until the returned function is called.
+// Must be called with the lock held, the returned function too.
+func (self *SrtmFile) pin() func() {
+	self.pins++
+	return func() {
+		self.pins--
+		if self.pins == 0 && self.unloadPending {
+			self.unload()
+		}
+	}
+}
+
+func (self *SrtmFile) unload() int {
+	if self.pins > 0 {
+		self.unloadPending = true
+		return 0
+	}
+	self.unloadPending = false
+	freed := self.loadedBytes()
+
+	if self.mapped {
+		if err := munmapFile(self.contents); err != nil {
+			self.logLevel.logf(LogWarn, "Error unmapping %s: %s", self.name, err.Error())
+		}
+		self.mapped = false
+	}
+	self.contents = nil
+	self.rows = nil
+	self.squareSize = 0
+
+	return freed
+}
+
+func (self *SrtmFile) getElevation(ctx context.Context, client *http.Client, storage SrtmLocalStorage, latitude, longitude float64) (float64, error) {
 	if !self.isValidSrtmFile || len(self.fileUrl) == 0 {
-		log.Printf("Invalid file %s", self.name)
+		self.logLevel.logf(LogWarn, "Invalid file %s", self.name)
 		return math.NaN(), nil
 	}
 
+	if len(self.contents) == 0 && len(self.rangeUrl) > 0 {
+		elevation, err := self.getElevationWithRangeRequest(ctx, client, latitude, longitude)
+		if err == nil {
+			return elevation, nil
+		}
+		self.logLevel.logf(LogWarn, "Error reading %s with range requests (%s) => retrieving the whole file", self.name, err.Error())
+		self.rangeUrl = ""
+	}
+
 	if len(self.contents) == 0 {
-		log.Println("load contents")
-		err := self.loadContents(client, storage)
+		self.logLevel.logf(LogDebug, "Loading the contents of %s", self.name)
+		err := self.loadContents(ctx, client, storage)
 		if err != nil {
 			return math.NaN(), err
 		}
 	}
 
 	if self.squareSize <= 0 {
-		squareSizeFloat := math.Sqrt(float64(len(self.contents)) / 2.0)
-		self.squareSize = int(squareSizeFloat)
-
-		if squareSizeFloat != float64(self.squareSize) || self.squareSize <= 0 {
-			return math.NaN(), errors.New(fmt.Sprintf("Invalid size for file %s: %d", self.name, len(self.contents)))
-		}
+		return math.NaN(), fmt.Errorf("%w: invalid size for file %s: %d", ErrCorruptTile, self.name, len(self.contents))
 	}
 
 	row, column := self.getRowAndColumn(latitude, longitude)
@@ -191,17 +1234,17 @@ func (self *SrtmFile) getElevation(client *http.Client, storage SrtmLocalStorage
 	return elevation, nil
 }
 
-func (self SrtmFile) getElevationFromRowAndColumn(row, column int) float64 {
-	i := row*self.squareSize + column
-	byte1 := self.contents[i*2]
-	byte2 := self.contents[i*2+1]
-	result := int(byte1)*256 + int(byte2)
-
-	if result > 9000 {
-		return math.NaN()
+// getSample returns the raw value of the i-th sample
+func (self *SrtmFile) getSample(i int) int16 {
+	if self.littleEndian {
+		return int16(uint16(self.contents[i*2+1])<<8 | uint16(self.contents[i*2]))
 	}
+	return int16(uint16(self.contents[i*2])<<8 | uint16(self.contents[i*2+1]))
+}
 
-	return float64(result)
+func (self SrtmFile) getElevationFromRowAndColumn(row, column int) float64 {
+	i := row*self.squareSize + column
+	return self.decodeSample(self.contents, i)
 	/*
 	   i = row * (@square_side) + column
 
@@ -223,87 +1266,77 @@ func (self SrtmFile) getElevationFromRowAndColumn(row, column int) float64 {
 	*/
 }
 
-func (self SrtmFile) getRowAndColumn(latitude, longitude float64) (int, int) {
-	row := int((self.latitude + 1.0 - latitude) * (float64(self.squareSize - 1.0)))
-	column := int((longitude - self.longitude) * (float64(self.squareSize - 1.0)))
-	//log.Printf("squareSize=%v", self.squareSize)
-	//log.Printf("row, column = %v, %v", row, column)
-	return row, column
-}
+// voidValue is the value of SRTM samples without data
+const voidValue = -32768
 
-// ----------------------------------------------------------------------------------------------------
-// Misc util functions
-// ----------------------------------------------------------------------------------------------------
+// decodeSample decodes the i-th sample of the bytes (all or part of the tile) in the byte order of the tile
+func (self *SrtmFile) decodeSample(bytes []byte, i int) float64 {
+	if self.littleEndian {
+		return decodeElevation(bytes[i*2+1], bytes[i*2])
+	}
+	return decodeElevation(bytes[i*2], bytes[i*2+1])
+}
 
-func LoadSrtmData(client *http.Client) (*SrtmData, error) {
-	result := new(SrtmData)
+// decodeElevation decodes a (big-endian, signed) sample, voids (and invalid values) are NaN
+func decodeElevation(byte1, byte2 byte) float64 {
+	result := int16(uint16(byte1)<<8 | uint16(byte2))
 
-	var err error
-	result.Srtm1BaseUrl = SRTM_BASE_URL + SRTM1_URL
-	result.Srtm1, err = getLinksFromUrl(client, result.Srtm1BaseUrl, result.Srtm1BaseUrl, 0)
-	if err != nil {
-		return nil, err
+	if result == voidValue || result > 9000 {
+		return math.NaN()
 	}
 
-	result.Srtm3BaseUrl = SRTM_BASE_URL + SRTM3_URL
-	result.Srtm3, err = getLinksFromUrl(client, result.Srtm3BaseUrl, result.Srtm3BaseUrl, 0)
-	if err != nil {
-		return nil, err
-	}
+	return float64(result)
+}
 
-	return result, nil
+func (self SrtmFile) getRowAndColumn(latitude, longitude float64) (int, int) {
+	row, column := self.getPosition(latitude, longitude)
+	//log.Printf("squareSize=%v", self.squareSize)
+	//log.Printf("row, column = %v, %v", row, column)
+	return int(row), int(column)
 }
 
-func getLinksFromUrl(client *http.Client, baseUrl, url string, depth int) ([]SrtmUrl, error) {
-	if depth >= 2 {
-		return []SrtmUrl{}, nil
+// getPosition returns the fractional row and column of the point, see TileScheme
+func (self SrtmFile) getPosition(latitude, longitude float64) (float64, float64) {
+	if self.scheme != nil {
+		return self.scheme.Position(self.latitude, self.longitude, self.squareSize, latitude, longitude)
 	}
+	return SrtmTileScheme{}.Position(self.latitude, self.longitude, self.squareSize, latitude, longitude)
+}
 
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, err
-	}
+// ----------------------------------------------------------------------------------------------------
+// Misc util functions
+// ----------------------------------------------------------------------------------------------------
 
-	result := make([]SrtmUrl, 0)
-
-	urls := getLinksFromHtmlDocument(resp.Body)
-	for _, tmpUrl := range urls {
-		urlLowercase := strings.ToLower(tmpUrl)
-		if strings.HasSuffix(urlLowercase, ".hgt.zip") {
-			parts := strings.Split(tmpUrl, "/")
-			name := parts[len(parts)-1]
-			name = strings.Replace(name, ".hgt.zip", "", -1)
-			u := strings.Replace(fmt.Sprintf("%s/%s", url, tmpUrl), baseUrl, "", 1)
-			srtmUrl := SrtmUrl{Name: name, Url: u}
-			result = append(result, srtmUrl)
-			log.Printf("> %s/%s -> %s\n", url, tmpUrl, tmpUrl)
-		} else if len(urlLowercase) > 0 && urlLowercase[0] != '/' && !strings.HasPrefix(urlLowercase, "http") && !strings.HasSuffix(urlLowercase, ".jpg") {
-			newLinks, err := getLinksFromUrl(client, baseUrl, fmt.Sprintf("%s/%s", url, tmpUrl), depth+1)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, newLinks...)
-			log.Printf("> %s\n", tmpUrl)
-		}
-	}
+func LoadSrtmData(client *http.Client) (*SrtmData, error) {
+	return LoadSrtmDataContext(context.Background(), client)
+}
 
-	return result, nil
+// LoadSrtmDataContext is like LoadSrtmData, but the crawl stops (with ctx.Err()) when the context is done
+func LoadSrtmDataContext(ctx context.Context, client *http.Client) (*SrtmData, error) {
+	return newSrtmCrawler(ctx, client).loadSrtmDataFromMirrors(DefaultMirrors)
 }
 
-func getLinksFromHtmlDocument(html io.ReadCloser) []string {
-	result := make([]string, 10)
+// getLinksFromHtmlDocument returns the href attributes of all the tags, the HTML doesn't have to be well-formed
+// (directory listings often have unclosed tags and unescaped characters)
+func getLinksFromHtmlDocument(document io.Reader) []string {
+	result := make([]string, 0)
 
-	decoder := xml.NewDecoder(html)
-	for token, _ := decoder.Token(); token != nil; token, _ = decoder.Token() {
-		switch typedToken := token.(type) {
-		case xml.StartElement:
-			for _, attr := range typedToken.Attr {
-				if strings.ToLower(attr.Name.Local) == "href" {
-					result = append(result, strings.Trim(attr.Value, " \r\t\n"))
+	tokenizer := html.NewTokenizer(document)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// io.EOF at the end of the document:
+			return result
+		case html.StartTagToken, html.SelfClosingTagToken:
+			for {
+				key, value, more := tokenizer.TagAttr()
+				if strings.ToLower(string(key)) == "href" {
+					result = append(result, strings.Trim(string(value), " \r\t\n"))
+				}
+				if !more {
+					break
 				}
 			}
 		}
 	}
-
-	return result
 }
@@ -0,0 +1,202 @@
+package geoelevations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (self *SrtmFile) getElevationWithRangeRequest(ctx context.Context, client *http.Client, latitude, longitude float64) (float64, error) {
+	for self.rangeRequestsNeeded(latitude, longitude) > 0 {
+		if err := self.nextRangeRequest(ctx, client, latitude, longitude); err != nil {
+			return math.NaN(), err
+		}
+	}
+
+	row, column := self.getRowAndColumn(latitude, longitude)
+	if len(self.contents) > 0 {
+		// The server returned the whole file:
+		return self.getElevationFromRowAndColumn(row, column), nil
+	}
+	return self.decodeSample(self.rows[row], column), nil
+}
+
+// rangeRequestsNeeded returns the number of range requests still needed to read the elevation of the point
+func (self *SrtmFile) rangeRequestsNeeded(latitude, longitude float64) int {
+	if len(self.contents) > 0 || len(self.rangeUrl) == 0 {
+		return 0
+	}
+	if self.squareSize <= 0 {
+		// The first sample (for the file size), then the row:
+		return 2
+	}
+	row, _ := self.getRowAndColumn(latitude, longitude)
+	if _, found := self.rows[row]; found {
+		return 0
+	}
+	return 1
+}
+
+// nextRangeRequest retrieves the file size (with the first sample) if not known yet, otherwise the row of the point
+func (self *SrtmFile) nextRangeRequest(ctx context.Context, client *http.Client, latitude, longitude float64) error {
+	if self.squareSize <= 0 {
+		body, total, err := self.getRange(ctx, client, 0, 2)
+		if err != nil {
+			return err
+		}
+		if total < 0 {
+			// Not a partial response, the server returned the whole file:
+			squareSize, err := self.validateContents(body)
+			if err != nil {
+				return err
+			}
+			self.contents = body
+			self.squareSize = squareSize
+			return nil
+		}
+
+		self.squareSize, err = self.getSquareSize(total)
+		return err
+	}
+
+	row, column := self.getRowAndColumn(latitude, longitude)
+	if row < 0 || row >= self.squareSize || column < 0 || column >= self.squareSize {
+		return fmt.Errorf("%w: invalid row/column for %s: %d,%d", ErrInvalidCoordinate, self.name, row, column)
+	}
+
+	rowLength := self.squareSize * 2
+	body, total, err := self.getRange(ctx, client, row*rowLength, rowLength)
+	if err != nil {
+		return err
+	}
+	if total < 0 || len(body) != rowLength {
+		return fmt.Errorf("%w: invalid range response for %s, row %d: %d bytes", ErrCorruptTile, self.name, row, len(body))
+	}
+	if self.rows == nil {
+		self.rows = make(map[int][]byte)
+	}
+	self.rows[row] = body
+	self.logLevel.logf(LogDebug, "Retrieved row %d of %s", row, self.name)
+	return nil
+}
+
+// readRangeRequests retrieves (with range requests) what is needed to read the elevation of the point. The lock is
+// released while waiting for the rate limit (if any) and during the requests, so that the queries of other tiles
+// don't wait. If the range
+// requests fail, the tile is retrieved as a whole file instead (rangeUrl is cleared). Must be called with the lock
+// held.
+func (self *Srtm) readRangeRequests(ctx context.Context, client *http.Client, srtmFile *SrtmFile, latitude, longitude float64) error {
+	// Every request waits here, not in the transport (which would wait with the lock held):
+	requestCtx := context.WithValue(ctx, rateLimitWaitedKey{}, true)
+	for srtmFile.rangeRequestsNeeded(latitude, longitude) > 0 {
+		if self.limiter != nil {
+			self.lock.Unlock()
+			err := self.limiter.Wait(ctx)
+			self.lock.Lock()
+			if err != nil {
+				return err
+			}
+			// The tile can be read (or unloaded) by other queries while the lock is released:
+			if srtmFile.rangeRequestsNeeded(latitude, longitude) == 0 {
+				break
+			}
+		}
+
+		// Retrieved into a copy, the lock is released during the request:
+		downloads := srtmFile.downloads
+		loading := *srtmFile
+		loading.rows = nil
+		self.lock.Unlock()
+		err := loading.nextRangeRequest(requestCtx, client, latitude, longitude)
+		self.lock.Lock()
+		srtmFile.downloads += loading.downloads - downloads
+		self.stats.Downloads += loading.downloads - downloads
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			self.logLevel.logf(LogWarn, "Error reading %s with range requests (%s) => retrieving the whole file", srtmFile.name, err.Error())
+			srtmFile.rangeUrl = ""
+			return nil
+		}
+		srtmFile.addRangeResponse(&loading)
+	}
+	if len(srtmFile.contents) > 0 {
+		self.tileLoaded(srtmFile)
+	}
+	return nil
+}
+
+// addRangeResponse adds what was retrieved by a range request into a copy of the tile (unless loaded meanwhile)
+func (self *SrtmFile) addRangeResponse(retrieved *SrtmFile) {
+	if len(self.contents) > 0 {
+		return
+	}
+	if len(retrieved.contents) > 0 {
+		// The server returned the whole file:
+		self.contents, self.squareSize = retrieved.contents, retrieved.squareSize
+		return
+	}
+	if self.squareSize <= 0 {
+		self.squareSize = retrieved.squareSize
+	}
+	for row, body := range retrieved.rows {
+		if self.rows == nil {
+			self.rows = make(map[int][]byte)
+		}
+		self.rows[row] = body
+	}
+}
+
+// getRange retrieves length bytes of the uncompressed file, starting with offset. Returns the total file size
+// from the Content-Range header, or -1 if the server ignored the range and returned the whole file.
+func (self *SrtmFile) getRange(ctx context.Context, client *http.Client, offset, length int) ([]byte, int, error) {
+	self.downloads++
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, self.rangeUrl, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	response, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, fmt.Errorf("%w: error retrieving %s", ctx.Err(), self.rangeUrl)
+		}
+		return nil, 0, fmt.Errorf("%w: error retrieving %s: %s", ErrOffline, self.rangeUrl, err.Error())
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		total, err := parseContentRangeTotal(response.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, err
+		}
+		body, err := readResponseBody(response)
+		return body, total, err
+	case http.StatusOK:
+		body, err := readResponseBody(response)
+		return body, -1, err
+	}
+
+	return nil, 0, fmt.Errorf("%w: %s returned %s", ErrTileNotAvailable, self.rangeUrl, response.Status)
+}
+
+// parseContentRangeTotal parses the total size from a "bytes 0-1/2884802" header
+func parseContentRangeTotal(contentRange string) (int, error) {
+	parts := strings.Split(contentRange, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bytes ") {
+		return 0, errors.New(fmt.Sprintf("Invalid Content-Range: %s", contentRange))
+	}
+	total, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid Content-Range: %s", contentRange))
+	}
+	return total, nil
+}
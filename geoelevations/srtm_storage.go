@@ -1,33 +1,104 @@
 package geoelevations
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// TileFileNamer returns the name (without the extension) of the file caching a tile in the storage. The name can
+// have a directory, but must end with the tile name (for example "srtm1/N47E008").
+type TileFileNamer func(name string, resolution SrtmResolution) string
+
+// FlatTileFileNames caches all tiles in the root of the storage (for example "N47E008"), this is the default
+func FlatTileFileNames(name string, resolution SrtmResolution) string {
+	return name
+}
+
+// ResolutionTileFileNames caches tiles in a directory per resolution (for example "srtm1/N47E008"), so that tiles
+// with different resolutions don't overwrite each other
+func ResolutionTileFileNames(name string, resolution SrtmResolution) string {
+	return fmt.Sprintf("srtm%d/%s", resolution, name)
+}
+
 type SrtmLocalStorage interface {
 	// LoadFile loads a file, if not available, then err!=nil and IsNotExists(err) must be true
 	LoadFile(fn string) ([]byte, error)
 	IsNotExists(err error) bool
 	SaveFile(fn string, bytes []byte) error
+	// Exists checks if the file is available (without loading it)
+	Exists(fn string) (bool, error)
+	// Delete removes the file, deleting a file which doesn't exist is not an error
+	Delete(fn string) error
+}
+
+// SrtmStreamingStorage is implemented by storages able to open files for reading without loading them into memory
+type SrtmStreamingStorage interface {
+	SrtmLocalStorage
+	// Open opens a file for reading, if not available, then err!=nil and IsNotExists(err) must be true
+	Open(fn string) (io.ReadCloser, error)
+}
+
+// openStorageFile opens a file for reading, storages without Open are adapted with LoadFile
+func openStorageFile(storage SrtmLocalStorage, fn string) (io.ReadCloser, error) {
+	if streamingStorage, ok := storage.(SrtmStreamingStorage); ok {
+		return streamingStorage.Open(fn)
+	}
+	byts, err := storage.LoadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(byts)), nil
+}
+
+// SrtmModTimeStorage is implemented by storages which know when files were saved
+type SrtmModTimeStorage interface {
+	SrtmLocalStorage
+	// ModTime returns the last modification time of the file, if not available, then err!=nil and
+	// IsNotExists(err) must be true
+	ModTime(fn string) (time.Time, error)
+}
+
+// SrtmListingStorage is implemented by storages able to list their files
+type SrtmListingStorage interface {
+	SrtmLocalStorage
+	// List returns the sizes of all the files, by name
+	List() (map[string]int64, error)
+}
+
+// SrtmLocalFilePathStorage is implemented by storages keeping files on the local filesystem
+type SrtmLocalFilePathStorage interface {
+	SrtmLocalStorage
+	FilePath(fn string) string
 }
 
 type LocalFileSrtmStorage struct {
 	cacheDirectory string
 }
 
+// NewLocalFileSrtmStorage caches the files in cacheDirectory, or in the default directory if empty, see
+// DefaultCacheDirectory
 func NewLocalFileSrtmStorage(cacheDirectory string) (*LocalFileSrtmStorage, error) {
+	return newLocalFileSrtmStorage(cacheDirectory, LogDebug)
+}
+
+// newLocalFileSrtmStorage is NewLocalFileSrtmStorage, logging with the minimum level
+func newLocalFileSrtmStorage(cacheDirectory string, logLevel LogLevel) (*LocalFileSrtmStorage, error) {
 	if len(cacheDirectory) == 0 {
-		cacheDirectory = path.Join(os.Getenv("HOME"), ".geoelevations")
+		cacheDirectory = DefaultCacheDirectory()
 	}
-	log.Printf("Using %s to cache SRTM files", cacheDirectory)
+	logLevel.logf(LogInfo, "Using %s to cache SRTM files", cacheDirectory)
 
 	if _, err := os.Stat(cacheDirectory); os.IsNotExist(err) {
-		log.Print("Creating", cacheDirectory)
+		logLevel.logf(LogInfo, "Creating %s", cacheDirectory)
 
-		if err := os.Mkdir(cacheDirectory, os.ModeDir|0700); err != nil {
+		if err := os.MkdirAll(cacheDirectory, os.ModeDir|0700); err != nil {
 			return nil, err
 		}
 	}
@@ -35,26 +106,220 @@ func NewLocalFileSrtmStorage(cacheDirectory string) (*LocalFileSrtmStorage, erro
 	return &LocalFileSrtmStorage{cacheDirectory: cacheDirectory}, nil
 }
 func (ds LocalFileSrtmStorage) LoadFile(fn string) ([]byte, error) {
-	f, err := os.Open(path.Join(ds.cacheDirectory, fn))
+	f, err := ds.Open(fn)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	bytes, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
 	return bytes, nil
 }
+func (ds LocalFileSrtmStorage) Open(fn string) (io.ReadCloser, error) {
+	return os.Open(path.Join(ds.cacheDirectory, fn))
+}
 func (ds LocalFileSrtmStorage) IsNotExists(err error) bool {
 	return os.IsNotExist(err)
 }
 func (ds LocalFileSrtmStorage) SaveFile(fn string, bytes []byte) error {
-	f, err := os.Create(path.Join(ds.cacheDirectory, fn))
+	// File names can have a directory, see TileFileNamer:
+	if err := os.MkdirAll(path.Dir(path.Join(ds.cacheDirectory, fn)), os.ModeDir|0700); err != nil {
+		return err
+	}
+	// Written to a temporary file first, an interrupted write must never end up as the cached file:
+	filePath := path.Join(ds.cacheDirectory, fn)
+	f, err := ioutil.TempFile(path.Dir(filePath), "."+path.Base(filePath)+".tmp")
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(bytes)
-	return err
+	if err := writeStorageFile(f, bytes); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// writeStorageFile writes (and syncs) the contents of a file saved in LocalFileSrtmStorage
+var writeStorageFile = func(f *os.File, bytes []byte) error {
+	if _, err := f.Write(bytes); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (ds LocalFileSrtmStorage) Exists(fn string) (bool, error) {
+	_, err := os.Stat(path.Join(ds.cacheDirectory, fn))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+func (ds LocalFileSrtmStorage) Delete(fn string) error {
+	err := os.Remove(path.Join(ds.cacheDirectory, fn))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+func (ds LocalFileSrtmStorage) ModTime(fn string) (time.Time, error) {
+	stat, err := os.Stat(path.Join(ds.cacheDirectory, fn))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+func (ds LocalFileSrtmStorage) List() (map[string]int64, error) {
+	result := map[string]int64{}
+	err := filepath.Walk(ds.cacheDirectory, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			relative, err := filepath.Rel(ds.cacheDirectory, fn)
+			if err != nil {
+				return err
+			}
+			result[filepath.ToSlash(relative)] = info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+func (ds LocalFileSrtmStorage) FilePath(fn string) string {
+	return path.Join(ds.cacheDirectory, fn)
 }
 
 var _ SrtmLocalStorage = new(LocalFileSrtmStorage)
+var _ SrtmLocalFilePathStorage = new(LocalFileSrtmStorage)
+var _ SrtmStreamingStorage = new(LocalFileSrtmStorage)
+var _ SrtmModTimeStorage = new(LocalFileSrtmStorage)
+var _ SrtmListingStorage = new(LocalFileSrtmStorage)
+
+// DefaultCacheDirectory is the go-elevations directory in the user cache directory (os.UserCacheDir, for example
+// $XDG_CACHE_HOME/go-elevations on Linux). The ~/.geoelevations directory of older versions is used if it exists
+// (or if there is no user cache directory).
+func DefaultCacheDirectory() string {
+	legacyDirectory := path.Join(os.Getenv("HOME"), ".geoelevations")
+	if _, err := os.Stat(legacyDirectory); err == nil {
+		return legacyDirectory
+	}
+	userCacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		return legacyDirectory
+	}
+	return filepath.Join(userCacheDirectory, "go-elevations")
+}
+
+// writeTestFileName is saved (and deleted) to check if a storage is writable
+const writeTestFileName = ".write-test"
+
+// NewFallbackSrtmStorage returns the storage in the first of the cache directories which can be created and
+// written ("" is the default directory, see NewLocalFileSrtmStorage), or a MemorySrtmStorage if none can be
+// used. For example NewFallbackSrtmStorage("", path.Join(os.TempDir(), "geoelevations")).
+func NewFallbackSrtmStorage(cacheDirectories ...string) SrtmLocalStorage {
+	return newFallbackSrtmStorage(LogDebug, cacheDirectories...)
+}
+
+// newFallbackSrtmStorage is NewFallbackSrtmStorage, logging with the minimum level
+func newFallbackSrtmStorage(logLevel LogLevel, cacheDirectories ...string) SrtmLocalStorage {
+	for _, cacheDirectory := range cacheDirectories {
+		storage, err := newLocalFileSrtmStorage(cacheDirectory, logLevel)
+		if err == nil {
+			err = storage.SaveFile(writeTestFileName, nil)
+		}
+		if err == nil {
+			err = storage.Delete(writeTestFileName)
+		}
+		if err != nil {
+			logLevel.logf(LogWarn, "Can't use cache directory %s: %s", cacheDirectory, err.Error())
+			continue
+		}
+		logLevel.logf(LogInfo, "Caching SRTM files in %s", storage.cacheDirectory)
+		return storage
+	}
+	logLevel.logf(LogWarn, "No writable cache directory, caching SRTM files in memory")
+	return NewMemorySrtmStorage()
+}
+
+// MemorySrtmStorage keeps the files in memory, they are lost when the process exits
+type MemorySrtmStorage struct {
+	lock  sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	contents []byte
+	modTime  time.Time
+}
+
+func NewMemorySrtmStorage() *MemorySrtmStorage {
+	return &MemorySrtmStorage{files: map[string]memoryFile{}}
+}
+func (ms *MemorySrtmStorage) LoadFile(fn string) ([]byte, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	file, found := ms.files[fn]
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: fn, Err: os.ErrNotExist}
+	}
+	return file.contents, nil
+}
+func (ms *MemorySrtmStorage) IsNotExists(err error) bool {
+	return os.IsNotExist(err)
+}
+func (ms *MemorySrtmStorage) SaveFile(fn string, bytes []byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.files[fn] = memoryFile{contents: append([]byte(nil), bytes...), modTime: time.Now()}
+	return nil
+}
+func (ms *MemorySrtmStorage) Exists(fn string) (bool, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	_, found := ms.files[fn]
+	return found, nil
+}
+func (ms *MemorySrtmStorage) Delete(fn string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.files, fn)
+	return nil
+}
+func (ms *MemorySrtmStorage) ModTime(fn string) (time.Time, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	file, found := ms.files[fn]
+	if !found {
+		return time.Time{}, &os.PathError{Op: "stat", Path: fn, Err: os.ErrNotExist}
+	}
+	return file.modTime, nil
+}
+func (ms *MemorySrtmStorage) List() (map[string]int64, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	result := map[string]int64{}
+	for fn, file := range ms.files {
+		result[fn] = int64(len(file.contents))
+	}
+	return result, nil
+}
+
+var _ SrtmModTimeStorage = new(MemorySrtmStorage)
+var _ SrtmListingStorage = new(MemorySrtmStorage)
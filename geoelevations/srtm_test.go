@@ -1,10 +1,30 @@
 package geoelevations
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,6 +42,9 @@ func checkSrtmFileName(t *testing.T, latitude, longitude float64, expectedFileNa
 	if srtmLongitude != expectedSrtmLongitude {
 		t.Errorf("srtmLongitude != expectedSrtmLongitude ... %f != %f", srtmLongitude, expectedSrtmLongitude)
 	}
+	if math.Signbit(srtmLatitude) != math.Signbit(expectedSrtmLatitude) || math.Signbit(srtmLongitude) != math.Signbit(expectedSrtmLongitude) {
+		t.Errorf("Invalid sign of (%f, %f), expected (%f, %f)", srtmLatitude, srtmLongitude, expectedSrtmLatitude, expectedSrtmLongitude)
+	}
 }
 
 func TestFindSrtmFileName(t *testing.T) {
@@ -36,13 +59,32 @@ func TestFindSrtmFileName(t *testing.T) {
 	checkSrtmFileName(t, 25.1, -80, "N25W080", 25, -80)
 	checkSrtmFileName(t, -32, 152, "S32E152", -32, 152)
 
+	// Longitudes in the [0, 360] range:
+	checkSrtmFileName(t, 45, 350, "N45W010", 45, -10)
+	checkSrtmFileName(t, 45, -10, "N45W010", 45, -10)
+	checkSrtmFileName(t, 45, 349.5, "N45W011", 45, -11)
+
+	// Prime meridian and antimeridian:
+	checkSrtmFileName(t, 45, 0, "N45E000", 45, 0)
+	checkSrtmFileName(t, 45, math.Copysign(0, -1), "N45E000", 45, 0)
+	checkSrtmFileName(t, 45, -0.1, "N45W001", 45, -1)
+	checkSrtmFileName(t, 45, 180, "N45W180", 45, -180)
+	checkSrtmFileName(t, 45, -180, "N45W180", 45, -180)
+	checkSrtmFileName(t, 45, 179.9, "N45E179", 45, 179)
+	checkSrtmFileName(t, 45, 360, "N45E000", 45, 0)
+
+	// Poles:
+	checkSrtmFileName(t, 90, 13, "N89E013", 89, 13)
+	checkSrtmFileName(t, -90, 13, "S90E013", -90, 13)
+	checkSrtmFileName(t, math.Copysign(0, -1), 13, "N00E013", 0, 13)
+
 	// This file don't exists but the get_file_name is expected to return the supposed file:
 	checkSrtmFileName(t, 0, 0, "N00E000", 0, 0)
 }
 
 func checkElevation(t *testing.T, latitude, longitude, expectedElevation float64) {
 	srtm, _ := NewSrtm(http.DefaultClient)
-	elevation, err := srtm.GetElevation(http.DefaultClient, latitude, longitude)
+	elevation, err := srtm.GetElevation(latitude, longitude)
 	if err != nil {
 		t.Errorf("Valid coordinates but error getting elevation:%s", err.Error())
 		return
@@ -88,3 +130,2887 @@ func TestSrtm1AndSrtm3ForEurope(t *testing.T) {
 		t.Error("Europe should have both srtm1 and srtm3 urls")
 	}
 }
+
+func zipTile(t testing.TB, name string, contents []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.Create(name)
+	assert.Nil(t, err)
+	_, err = f.Write(contents)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestTileSquareSizeValidation(t *testing.T) {
+	srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	assert.Nil(t, srtmFile.setContents(zipTile(t, "N45E013.hgt", make([]byte, 1201*1201*2))))
+	assert.Equal(t, 1201, srtmFile.squareSize)
+
+	// A perfect square, but not the size of a SRTM3 tile:
+	srtmFile = newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	assert.NotNil(t, srtmFile.setContents(zipTile(t, "N45E013.hgt", make([]byte, 1000*1000*2))))
+	assert.Empty(t, srtmFile.contents)
+}
+
+func TestZipAndHtmlSniffing(t *testing.T) {
+	assert.True(t, isZipBytes(zipTile(t, "N45E013.hgt", []byte{1, 2})))
+	assert.False(t, isZipBytes([]byte("<html><body>Not Found</body></html>")))
+	assert.True(t, isHtmlBytes([]byte("\n  <HTML><body>Not Found</body></html>")))
+	assert.True(t, isHtmlBytes([]byte("<!DOCTYPE html><html></html>")))
+	assert.False(t, isHtmlBytes(zipTile(t, "N45E013.hgt", []byte{1, 2})))
+}
+
+func TestMemoryMappedTile(t *testing.T) {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+
+	contents := make([]byte, 1201*1201*2)
+	contents[0], contents[1] = 0x01, 0x02
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.zip", zipTile(t, "N45E013.hgt", contents)))
+
+	srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	srtmFile.memoryMap = true
+	assert.Nil(t, srtmFile.loadContents(context.Background(), nil, storage))
+	assert.Equal(t, 1201, srtmFile.squareSize)
+	assert.Equal(t, float64(0x0102), srtmFile.getElevationFromRowAndColumn(0, 0))
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		assert.True(t, srtmFile.mapped)
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	contents := make([]byte, 1201*1201*2)
+	// Row 600, column 600:
+	i := (600*1201 + 600) * 2
+	contents[i], contents[i+1] = 0x01, 0x02
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "N45E013.hgt", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+
+	srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	srtmFile.rangeUrl = server.URL + "/N45E013.hgt"
+	elevation, err := srtmFile.getElevationWithRangeRequest(context.Background(), http.DefaultClient, 45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0x0102), elevation)
+	assert.Equal(t, 1201, srtmFile.squareSize)
+	assert.Empty(t, srtmFile.contents)
+	assert.Equal(t, 2, requests)
+
+	// Same row, no new request:
+	elevation, err = srtmFile.getElevationWithRangeRequest(context.Background(), http.DefaultClient, 45.5, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), elevation)
+	assert.Equal(t, 2, requests)
+}
+
+func TestRangeRequestsWithoutLock(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		// The range is ignored, the whole file is returned:
+		_, _ = w.Write(testTileContents(200))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+	WithRangeRequests(server.URL + "/")(srtm)
+
+	done := make(chan float64)
+	go func() {
+		elevation, err := srtm.GetElevation(46.5, 13.5)
+		assert.Nil(t, err)
+		done <- elevation
+	}()
+
+	// While the whole N46E013 is read, the loaded tile can still be queried:
+	for start := time.Now(); atomic.LoadInt32(&requests) == 0; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("N46E013 not requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	elevation, err := srtm.GetElevation(45.6, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	close(release)
+	assert.Equal(t, 200.0, <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, 2, srtm.CacheStats().Tiles)
+}
+
+func TestRateLimit(t *testing.T) {
+	var lock sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		times = append(times, time.Now())
+		lock.Unlock()
+		if strings.HasSuffix(r.URL.Path, ".hgt") {
+			http.ServeContent(w, r, "N48E013.hgt", time.Time{}, bytes.NewReader(testTileContents(300)))
+			return
+		}
+		_, _ = w.Write(zipTile(t, strings.TrimSuffix(path.Base(r.URL.Path), ".zip"), testTileContents(200)))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	WithRateLimit(10)(srtm)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	for _, name := range []string{"N46E013", "N47E013", "N48E013"} {
+		srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: name, Url: name + ".hgt.zip"})
+	}
+	for _, latitude := range []float64{46.5, 47.5} {
+		elevation, err := srtm.GetElevation(latitude, 13.5)
+		assert.Nil(t, err)
+		assert.Equal(t, 200.0, elevation)
+	}
+	assert.Equal(t, 2, len(times))
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), 80*time.Millisecond)
+
+	// Waiting for the limiter stops when the context is done:
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := srtm.GetElevationContext(ctx, 48.5, 13.5)
+	assert.True(t, errors.Is(err, context.Canceled), fmt.Sprint(err))
+	assert.Equal(t, 2, len(times))
+
+	// Range requests wait without the lock, queries of loaded tiles don't wait for them:
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	WithRateLimit(2)(srtm)
+	WithRangeRequests(server.URL + "/")(srtm)
+	delete(srtm.cache, "N48E013")
+	srtm.lastSrtmFile = nil
+	done := make(chan float64)
+	go func() {
+		elevation, err := srtm.GetElevation(48.5, 13.5)
+		assert.Nil(t, err)
+		done <- elevation
+	}()
+	time.Sleep(100 * time.Millisecond)
+	started := time.Now()
+	elevation, err = srtm.GetElevation(45.6, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Less(t, time.Since(started), 200*time.Millisecond)
+	assert.Equal(t, 300.0, <-done)
+	lock.Lock()
+	assert.Equal(t, 4, len(times))
+	assert.GreaterOrEqual(t, times[3].Sub(times[2]), 400*time.Millisecond)
+	lock.Unlock()
+
+	WithRateLimit(0)(srtm)
+	assert.Nil(t, srtm.limiter)
+}
+
+// newTestSrtm prepares a Srtm with the given SRTM3 tiles (filled with elevation) in a temporary storage
+func newTestSrtm(t testing.TB, elevation int16, tiles ...string) *Srtm {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+
+	srtm := &Srtm{
+		cache:    make(map[string]*SrtmFile),
+		client:   http.DefaultClient,
+		storage:  storage,
+		srtmData: SrtmData{Srtm3BaseUrl: "http://localhost/"},
+	}
+	for _, tile := range tiles {
+		addTestTile(t, srtm, tile, testTileContents(elevation))
+	}
+
+	return srtm
+}
+
+// testTileContents creates the contents of a SRTM3 tile filled with elevation
+func testTileContents(elevation int16) []byte {
+	contents := make([]byte, 1201*1201*2)
+	for i := 0; i < len(contents); i += 2 {
+		setTestSample(contents, 1201, i/2/1201, i/2%1201, elevation)
+	}
+	return contents
+}
+
+func setTestSample(contents []byte, squareSize, row, column int, elevation int16) {
+	i := (row*squareSize + column) * 2
+	contents[i], contents[i+1] = byte(uint16(elevation)>>8), byte(uint16(elevation))
+}
+
+// addTestTile saves the (SRTM3) tile in the storage and adds it to the index
+func addTestTile(t testing.TB, srtm *Srtm, tile string, contents []byte) {
+	assert.Nil(t, srtm.storage.SaveFile(tile+".hgt.zip", zipTile(t, tile+".hgt", contents)))
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: tile, Url: tile + ".hgt.zip"})
+}
+
+func TestMaxLoadedTiles(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014", "N46E013")
+	WithMaxLoadedTiles(2)(srtm)
+
+	for _, coordinates := range [][2]float64{{45.5, 13.5}, {45.5, 14.5}, {46.5, 13.5}, {45.6, 14.6}} {
+		elevation, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.Nil(t, err)
+		assert.Equal(t, 100.0, elevation)
+	}
+
+	assert.False(t, srtm.cache["N45E013"].isLoaded())
+	assert.True(t, srtm.cache["N45E014"].isLoaded())
+	assert.True(t, srtm.cache["N46E013"].isLoaded())
+
+	assert.True(t, srtm.UnloadTile("N46E013"))
+	assert.False(t, srtm.UnloadTile("N46E013"))
+	assert.False(t, srtm.cache["N46E013"].isLoaded())
+
+	assert.True(t, srtm.EvictTile(45.5, 14.5))
+	assert.False(t, srtm.EvictTile(45.5, 14.5))
+	assert.False(t, srtm.EvictTile(math.NaN(), 14.5))
+	// Kept in the index:
+	assert.True(t, srtm.HasTile(45.5, 14.5))
+
+	// Region queries over more tiles than the limit:
+	addTestTile(t, srtm, "N45E015", testTileContents(100))
+	for _, query := range []func() (float64, error){
+		func() (float64, error) {
+			stats, err := srtm.RegionStats(45.1, 13.1, 46.9, 15.9)
+			return stats.Mean, err
+		},
+		func() (float64, error) {
+			return srtm.RegionRuggedness(45.1, 13.1, 45.9, 15.9)
+		},
+	} {
+		result, err := query()
+		assert.Nil(t, err)
+		assert.False(t, math.IsNaN(result))
+		assert.LessOrEqual(t, srtm.CacheStats().Tiles, 2)
+	}
+	for _, longitude := range []float64{13.5, 14.5, 15.5} {
+		_, _, err := srtm.GetTileGrid(45.5, longitude)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, srtm.CacheStats().Tiles, 2)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "N45") {
+			_, _ = w.Write([]byte("<html><body>Not Found</body></html>"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+
+	srtmFile := newSrtmFile("N45E013", server.URL+"/N45E013.hgt.zip", SRTM3, 45, 13)
+	_, err = srtmFile.getElevation(context.Background(), http.DefaultClient, storage, 45.5, 13.5)
+	assert.True(t, errors.Is(err, ErrCorruptTile))
+	_, err = storage.LoadFile("N45E013.hgt.zip")
+	assert.True(t, storage.IsNotExists(err))
+
+	srtmFile = newSrtmFile("N46E013", server.URL+"/N46E013.hgt.zip", SRTM3, 46, 13)
+	_, err = srtmFile.getElevation(context.Background(), http.DefaultClient, storage, 46.5, 13.5)
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+
+	srtmFile = newSrtmFile("N46E013", "http://127.0.0.1:1/N46E013.hgt.zip", SRTM3, 46, 13)
+	_, err = srtmFile.getElevation(context.Background(), http.DefaultClient, storage, 46.5, 13.5)
+	assert.True(t, errors.Is(err, ErrOffline))
+}
+
+func TestInvalidCoordinates(t *testing.T) {
+	srtm := newTestSrtm(t, 100)
+	for _, coordinates := range [][2]float64{{200, 13}, {-90.1, 13}, {45, 5000}, {45, 360.5}, {45, -180.5}, {math.NaN(), 13}, {45, math.Inf(1)}} {
+		elevation, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.True(t, errors.Is(err, ErrInvalidCoordinate), "%v", coordinates)
+		assert.True(t, math.IsNaN(elevation))
+	}
+	assert.Empty(t, srtm.cache)
+}
+
+func TestMissingTileAsSeaLevel(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	elevation, err := srtm.GetElevation(44.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+
+	WithMissingTileAsSeaLevel()(srtm)
+	elevation, err = srtm.GetElevation(44.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, elevation)
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+}
+
+type funcProvider func(latitude, longitude float64) (float64, error)
+
+func (self funcProvider) GetElevation(latitude, longitude float64) (float64, error) {
+	return self(latitude, longitude)
+}
+
+func TestChainProvider(t *testing.T) {
+	failing := funcProvider(func(latitude, longitude float64) (float64, error) { return math.NaN(), ErrOffline })
+	missing := funcProvider(func(latitude, longitude float64) (float64, error) { return math.NaN(), nil })
+	valid := funcProvider(func(latitude, longitude float64) (float64, error) { return 10, nil })
+
+	elevation, err := NewChainProvider(failing, missing, valid).GetElevation(45, 13)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, elevation)
+
+	elevation, err = NewChainProvider(missing, failing, missing).GetElevation(45, 13)
+	assert.True(t, errors.Is(err, ErrOffline))
+	assert.True(t, math.IsNaN(elevation))
+
+	static := NewStaticProvider(nil).Set(45, 13, 20)
+	elevation, err = NewChainProvider(static, valid).GetElevation(45, 13)
+	assert.Nil(t, err)
+	assert.Equal(t, 20.0, elevation)
+	elevation, err = NewChainProvider(static, valid).GetElevation(46, 13)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, elevation)
+
+	elevation, err = NewChainProvider(missing, newTestSrtm(t, 100, "N45E013")).GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+}
+
+func TestClose(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	WithMemoryMappedTiles()(srtm)
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	srtmFile := srtm.cache["N45E013"]
+	assert.True(t, srtmFile.isLoaded())
+
+	assert.Nil(t, srtm.Close())
+	assert.False(t, srtmFile.isLoaded())
+	assert.False(t, srtmFile.mapped)
+	assert.Empty(t, srtm.cache)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+}
+
+func TestClearCache(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			elevation, err := srtm.GetElevation(45.5, 13.5+float64(i%2))
+			assert.Nil(t, err)
+			assert.Equal(t, 100.0, elevation)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2*1201*1201*2, srtm.ClearCache())
+	assert.Equal(t, 0, srtm.ClearCache())
+	assert.Len(t, srtm.cache, 2)
+}
+
+func TestCacheStats(t *testing.T) {
+	contents := make([]byte, 1201*1201*2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(zipTile(t, "N46E013.hgt", contents))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+
+	for _, coordinates := range [][2]float64{{45.5, 13.5}, {45.6, 13.6}, {46.5, 13.5}, {46.6, 13.6}, {46.7, 13.7}, {44.5, 13.5}} {
+		_, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, CacheStats{Tiles: 2, Bytes: 2 * len(contents), Hits: 3, Misses: 2, Downloads: 1}, srtm.CacheStats())
+}
+
+func TestLocalFileStorageExistsAndDelete(t *testing.T) {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+
+	exists, err := storage.Exists("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.zip", []byte("test")))
+	exists, err = storage.Exists("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	assert.Nil(t, storage.Delete("N45E013.hgt.zip"))
+	exists, err = storage.Exists("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, storage.Delete("N45E013.hgt.zip"))
+}
+
+// loadOnlyStorage hides the optional methods of the wrapped storage
+type loadOnlyStorage struct {
+	SrtmLocalStorage
+}
+
+func TestOpenStorageFile(t *testing.T) {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.zip", zipTile(t, "N45E013.hgt", make([]byte, 1201*1201*2))))
+
+	for _, s := range []SrtmLocalStorage{storage, loadOnlyStorage{storage}} {
+		_, err := openStorageFile(s, "N46E013.hgt.zip")
+		assert.True(t, s.IsNotExists(err))
+
+		srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+		assert.Nil(t, srtmFile.loadContents(context.Background(), nil, s))
+		assert.Equal(t, 1201, srtmFile.squareSize)
+	}
+}
+
+func TestPrefetchNeighbours(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014", "N46E013", "N46E014", "N44E013")
+	WithPrefetchNeighbours(5)(srtm)
+
+	// Near the north-east corner:
+	_, err := srtm.GetElevation(45.9999, 13.9999)
+	assert.Nil(t, err)
+	srtm.stopPrefetch()
+
+	assert.True(t, srtm.cache["N45E014"].isLoaded())
+	assert.True(t, srtm.cache["N46E013"].isLoaded())
+	assert.True(t, srtm.cache["N46E014"].isLoaded())
+	assert.Nil(t, srtm.cache["N44E013"])
+
+	// Not near the edge:
+	_, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Nil(t, srtm.Close())
+	assert.Empty(t, srtm.cache)
+}
+
+func TestDecodeElevation(t *testing.T) {
+	assert.Equal(t, 258.0, decodeElevation(0x01, 0x02))
+	assert.Equal(t, -5.0, decodeElevation(0xff, 0xfb))
+	assert.True(t, math.IsNaN(decodeElevation(0x80, 0x00)))
+}
+
+func TestInterpolateVoid(t *testing.T) {
+	contents := testTileContents(100)
+	// A void in the middle of the tile:
+	setTestSample(contents, 1201, 600, 600, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+}
+
+func TestInterpolateVoidAcrossTiles(t *testing.T) {
+	// A tile without data at all, next to one with data:
+	srtm := newTestSrtm(t, 200, "N45E014")
+	addTestTile(t, srtm, "N45E013", testTileContents(voidValue))
+
+	elevation, err := srtm.GetElevation(45.5, 13.9)
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, elevation)
+	assert.True(t, srtm.cache["N45E014"].isLoaded())
+
+	// Without neighbours:
+	srtm = newTestSrtm(t, 200)
+	addTestTile(t, srtm, "N45E013", testTileContents(voidValue))
+	elevation, err = srtm.GetElevation(45.5, 13.9)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+}
+
+func TestVoidSearchLimit(t *testing.T) {
+	contents := testTileContents(100)
+	for row := 595; row <= 605; row++ {
+		for column := 595; column <= 605; column++ {
+			setTestSample(contents, 1201, row, column, voidValue)
+		}
+	}
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	details, err := srtm.GetElevationDetails(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, ElevationDetails{Elevation: 100, Interpolated: true, MaxScanDistance: 6}, details)
+	details, err = srtm.GetElevationDetails(45.9, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, ElevationDetails{Elevation: 100}, details)
+
+	WithVoidSearchLimit(4)(srtm)
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+
+	elevation, reason, err := srtm.GetElevationReason(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+	assert.Equal(t, ReasonVoidUninterpolable, reason)
+
+	WithVoidFill(0)(srtm)
+	details, err = srtm.GetElevationDetails(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, ElevationDetails{Elevation: 0, Filled: true}, details)
+	WithVoidFillTileMean()(srtm)
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	// Not for missing tiles:
+	elevation, err = srtm.GetElevation(44.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+	srtm.voidFill, srtm.voidFillTileMean = nil, false
+	elevation, reason, err = srtm.GetElevationReason(45.9, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, "", reason)
+	elevation, reason, err = srtm.GetElevationReason(44.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+	assert.Equal(t, ReasonTileUnavailable, reason)
+}
+
+func TestInverseDistanceInterpolation(t *testing.T) {
+	contents := testTileContents(100)
+	for column := 595; column <= 605; column++ {
+		setTestSample(contents, 1201, 600, column, voidValue)
+	}
+	for column := 606; column < 1201; column++ {
+		setTestSample(contents, 1201, 600, column, 300)
+	}
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	// Row estimate 200, column estimate 100:
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 150.0, elevation)
+
+	// The close samples in the column dominate:
+	WithInterpolation(InterpolationInverseDistance)(srtm)
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.InDelta(t, (100.0/6+300.0/6+100+100)/(1.0/6+1.0/6+2), elevation, 0.0001)
+}
+
+func TestBilinearInterpolation(t *testing.T) {
+	contents := testTileContents(0)
+	for row := 0; row < 1201; row++ {
+		for column := 0; column < 1201; column++ {
+			setTestSample(contents, 1201, row, column, int16(column))
+		}
+	}
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	elevation, err := srtm.GetElevation(45.5, 13.0+600.25/1200)
+	assert.Nil(t, err)
+	assert.Equal(t, 600.0, elevation)
+	elevation, err = srtm.GetInterpolatedElevation(45.5, 13.0+600.25/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 600.25, elevation, 0.0001)
+
+	// Profiles are interpolated by default:
+	profile, err := srtm.ResampleTrack([][2]float64{{45.5, 13.0 + 600.25/1200}}, 100)
+	assert.Nil(t, err)
+	assert.InDelta(t, 600.25, profile[0].Elevation, 0.0001)
+	WithProfileInterpolation(InterpolationNearest)(srtm)
+	profile, err = srtm.ResampleTrack([][2]float64{{45.5, 13.0 + 600.25/1200}}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 600.0, profile[0].Elevation)
+
+	WithInterpolation(InterpolationBilinear)(srtm)
+	elevation, err = srtm.GetElevation(45.5, 13.0+600.25/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 600.25, elevation, 0.0001)
+
+	// The east edge doesn't need the neighbouring tile:
+	elevation, err = srtm.GetElevation(45.5, 13.99999999)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1200, elevation, 0.001)
+}
+
+func TestProfileVoidInterpolation(t *testing.T) {
+	// A void in (600, 600), with 100 one cell north, south and west, and 200 three cells east:
+	contents := testTileContents(100)
+	for column := 600; column <= 602; column++ {
+		setTestSample(contents, 1201, 600, column, voidValue)
+	}
+	setTestSample(contents, 1201, 600, 603, 200)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+	track := [][2]float64{{45.5, 13.5}}
+	linear := ((100*3+200)/4.0 + 100) / 2
+	inverseDistance := (100 + 100 + 100 + 200.0/3) / (3 + 1.0/3)
+
+	profile, err := srtm.ResampleTrack(track, 100)
+	assert.Nil(t, err)
+	assert.InDelta(t, linear, profile[0].Elevation, 0.0001)
+
+	// The profile interpolation is used for the voids too:
+	WithProfileInterpolation(InterpolationInverseDistance)(srtm)
+	profile, err = srtm.ResampleTrack(track, 100)
+	assert.Nil(t, err)
+	assert.InDelta(t, inverseDistance, profile[0].Elevation, 0.0001)
+
+	// And the global interpolation isn't:
+	srtm.profileInterpolation = nil
+	WithInterpolation(InterpolationInverseDistance)(srtm)
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.InDelta(t, inverseDistance, elevation, 0.0001)
+	profile, err = srtm.ResampleTrack(track, 100)
+	assert.Nil(t, err)
+	assert.InDelta(t, linear, profile[0].Elevation, 0.0001)
+}
+
+func TestRegionStats(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	contents := testTileContents(200)
+	setTestSample(contents, 1201, 600, 0, 300)
+	setTestSample(contents, 1201, 601, 0, voidValue)
+	addTestTile(t, srtm, "N45E014", contents)
+
+	stats, err := srtm.RegionStats(45.4, 13.9, 45.6, 14.1)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, stats.Min)
+	assert.Equal(t, 300.0, stats.Max)
+	assert.Equal(t, 1, stats.Voids)
+	// 241 rows, 121 columns in N45E013, 120 (+ the east edge) in N45E014:
+	assert.Equal(t, 241*241-1, stats.Count)
+	assert.InDelta(t, (241*120*100.0+241*121*200.0-200+100)/float64(stats.Count), stats.Mean, 0.0001)
+
+	// Without tiles:
+	stats, err = srtm.RegionStats(10, 10, 10.5, 10.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, stats.Count)
+	assert.True(t, math.IsNaN(stats.Mean))
+
+	_, err = srtm.RegionStats(46, 13, 45, 14)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+
+	// Longitudes in the [0, 360] range:
+	addTestTile(t, srtm, "N45W167", testTileContents(400))
+	stats, err = srtm.RegionStats(45.4, 193.4, 45.6, 193.6)
+	assert.Nil(t, err)
+	expected, err := srtm.RegionStats(45.4, -166.6, 45.6, -166.4)
+	assert.Nil(t, err)
+	assert.Equal(t, 241*241, stats.Count)
+	assert.Equal(t, 400.0, stats.Mean)
+	assert.Equal(t, expected, stats)
+	_, err = srtm.RegionStats(45.4, 179.5, 45.6, 180.5)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+}
+
+func TestElevationGain(t *testing.T) {
+	gain, loss := elevationGain([]float64{100, 101, 100, 102, 110, math.NaN(), 105, 100, 90}, 0)
+	assert.Equal(t, 11.0, gain)
+	assert.Equal(t, 21.0, loss)
+
+	// Small changes are noise, but a slow climb is still counted:
+	gain, loss = elevationGain([]float64{100, 101, 100, 102, 103, 104, 105, 106, 105}, 5)
+	assert.Equal(t, 5.0, gain)
+	assert.Equal(t, 0.0, loss)
+}
+
+func TestTrackElevationGain(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	addTestTile(t, srtm, "N45E014", testTileContents(150))
+
+	gain, loss, err := srtm.TrackElevationGain([][2]float64{{45.5, 13.5}, {45.5, 14.5}, {45.6, 13.6}}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 50.0, gain)
+	assert.Equal(t, 50.0, loss)
+
+	_, _, err = srtm.TrackElevationGain([][2]float64{{45.5, 13.5}, {95.5, 14.5}}, 1)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+
+	points := []Point{{Lat: 45.5, Lon: 13.5}, {Lat: 45.5, Lon: 14.5}, {Lat: 45.6, Lon: 13.6}}
+	elevations, err := srtm.GetElevationsForPoints(points)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{100, 150, 100}, elevations)
+	gain, loss, err = srtm.TrackElevationGainForPoints(points, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 50.0, gain)
+	assert.Equal(t, 50.0, loss)
+	profile, err := srtm.ResampleTrackForPoints(points[:2], 100000)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(profile))
+	assert.Equal(t, 150.0, profile[1].Elevation)
+}
+
+func TestGreatCircle(t *testing.T) {
+	// One degree of latitude:
+	assert.InDelta(t, 111195, haversineDistance(45, 13, 46, 13), 1)
+
+	latitude, longitude := intermediatePoint(45, 13, 46, 13, 0.5)
+	assert.InDelta(t, 45.5, latitude, 0.000001)
+	assert.InDelta(t, 13, longitude, 0.000001)
+	latitude, longitude = intermediatePoint(0, 10, 0, 20, 0.25)
+	assert.InDelta(t, 0, latitude, 0.000001)
+	assert.InDelta(t, 12.5, longitude, 0.000001)
+}
+
+func TestLineOfSight(t *testing.T) {
+	contents := testTileContents(100)
+	// A wall in column 600:
+	for row := 0; row < 1201; row++ {
+		setTestSample(contents, 1201, row, 600, 500)
+	}
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	visible, err := srtm.LineOfSight(45.5, 13.4, 2, 45.5, 13.6, 2)
+	assert.Nil(t, err)
+	assert.False(t, visible)
+
+	// High enough to see over the wall:
+	visible, err = srtm.LineOfSight(45.5, 13.4, 450, 45.5, 13.6, 450)
+	assert.Nil(t, err)
+	assert.True(t, visible)
+
+	// Not across the wall:
+	visible, err = srtm.LineOfSight(45.2, 13.1, 2, 45.25, 13.15, 2)
+	assert.Nil(t, err)
+	assert.True(t, visible)
+
+	// Not across the wall, but too far away to see over the curvature of the Earth:
+	visible, err = srtm.LineOfSight(45.2, 13.1, 2, 45.8, 13.4, 2)
+	assert.Nil(t, err)
+	assert.False(t, visible)
+}
+
+func TestTileNameAndBounds(t *testing.T) {
+	assert.Equal(t, "N45E013", TileName(45.2775, 13.726111))
+	assert.Equal(t, "S01W001", TileName(-0.5, -0.5))
+	assert.Equal(t, "N89W180", TileName(90, 180))
+
+	minLat, minLon, maxLat, maxLon, ok := TileBounds("N45E013")
+	assert.True(t, ok)
+	assert.Equal(t, []float64{45, 13, 46, 14}, []float64{minLat, minLon, maxLat, maxLon})
+	minLat, minLon, maxLat, maxLon, ok = TileBounds("s01w001.hgt")
+	assert.True(t, ok)
+	assert.Equal(t, []float64{-1, -1, 0, 0}, []float64{minLat, minLon, maxLat, maxLon})
+
+	for _, name := range []string{"", "N45E13", "X45E013", "N90E000", "N00E180", "S00E000", "N00W000", "N4aE013"} {
+		_, _, _, _, ok := TileBounds(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestHasTile(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "S01W001")
+
+	assert.True(t, srtm.HasTile(45.2775, 13.726111))
+	assert.True(t, srtm.HasTile(-0.5, 359.5))
+	assert.False(t, srtm.HasTile(75, 13))
+	assert.False(t, srtm.HasTile(math.NaN(), 13))
+	assert.Empty(t, srtm.cache)
+}
+
+func TestAvailableTiles(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "S01W001")
+	srtm.srtmData.Srtm1 = []SrtmUrl{{Name: "N45E013"}, {Name: "N10E010"}}
+
+	assert.Equal(t, []string{"N10E010", "N45E013", "S01W001"}, srtm.AvailableTiles())
+	assert.Equal(t, []string{}, (&SrtmData{}).AvailableTiles())
+}
+
+func zipFiles(t *testing.T, files ...[2]string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := w.Create(file[0])
+		assert.Nil(t, err)
+		_, err = f.Write([]byte(file[1]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestUnzipHGT(t *testing.T) {
+	contents, err := UnzipHGT(zipFiles(t, [2]string{"README.txt", "readme"}, [2]string{"N45E013.hgt", "\x00\x64"}))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 100}, contents)
+
+	// In a directory, with uppercase extension:
+	contents, err = UnzipHGT(zipFiles(t, [2]string{"N45E013/", ""}, [2]string{"N45E013/N45E013.HGT", "\x00\x65"}, [2]string{"N45E013/N45E013.md5", "x"}))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 101}, contents)
+
+	_, err = UnzipHGT(zipTile(t, "README.txt", []byte("readme")))
+	assert.NotNil(t, err)
+	_, err = UnzipHGT(zipFiles(t, [2]string{"N45E013.hgt", "\x00\x64"}, [2]string{"N45E014.hgt", "\x00\x64"}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "More than one")
+	_, err = UnzipHGT([]byte("not a zip"))
+	assert.NotNil(t, err)
+}
+
+func TestGzippedTiles(t *testing.T) {
+	gzipped, err := gzipBytes(&[]byte{})
+	assert.Nil(t, err)
+	assert.True(t, isGzipBytes(*gzipped))
+
+	contents := testTileContents(100)
+	gzipped, err = gzipBytes(&contents)
+	assert.Nil(t, err)
+	// Presized from the trailer, unless larger than a tile:
+	assert.Equal(t, len(contents), gzipSizeHint(bytes.NewReader(*gzipped), int64(len(*gzipped))))
+	assert.Equal(t, 0, gzipSizeHint(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), 4))
+	assert.Equal(t, 0, gzipSizeHint(bytes.NewReader(nil), 0))
+
+	// From the storage:
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.gz", *gzipped))
+	srtmFile := newSrtmFile("N45E013", "http://127.0.0.1:1/N45E013.hgt.zip", SRTM3, 45, 13)
+	elevation, err := srtmFile.getElevation(context.Background(), http.DefaultClient, storage, 45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	// Downloaded:
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/N46E013.hgt.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(*gzipped)
+	}))
+	defer server.Close()
+	srtmFile = newSrtmFile("N46E013", server.URL+"/N46E013.hgt.gz", SRTM3, 46, 13)
+	elevation, err = srtmFile.getElevation(context.Background(), http.DefaultClient, storage, 46.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	saved, err := storage.LoadFile("N46E013.hgt.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, *gzipped, saved)
+}
+
+func TestChecksums(t *testing.T) {
+	zipped := zipTile(t, "N45E013.hgt", testTileContents(100))
+	checksum := sha256.Sum256(zipped)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Truncated, but still a valid zip file:
+			_, _ = w.Write(zipTile(t, "N45E013.hgt", testTileContents(100)[:1000]))
+			return
+		}
+		_, _ = w.Write(zipped)
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip", Sha256: hex.EncodeToString(checksum[:])}}
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, 2, requests)
+
+	// Never matching:
+	srtm = newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+	WithChecksums(map[string]string{"N45E013": strings.Repeat("0", 64)})(srtm)
+	requests = 0
+	_, err = srtm.GetElevation(45.5, 13.5)
+	assert.True(t, errors.Is(err, ErrCorruptTile))
+	assert.Equal(t, downloadAttempts, requests)
+	_, err = srtm.storage.LoadFile("N45E013.hgt.zip")
+	assert.True(t, srtm.storage.IsNotExists(err))
+}
+
+func TestConditionalCrawl(t *testing.T) {
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := fmt.Sprintf("\"%s\"", r.URL.Path)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/SRTM3/"):
+			_, _ = w.Write([]byte(`<html><body><a href="Eurasia/">Eurasia</a></body></html>`))
+		case strings.HasSuffix(r.URL.Path, "/Eurasia/"):
+			_, _ = w.Write([]byte(`<html><body><a href="N45E013.hgt.zip">N45E013</a></body></html>`))
+		default:
+			_, _ = w.Write([]byte(`<html><body></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+
+	crawler := newSrtmCrawler(context.Background(), http.DefaultClient)
+	srtmData, err := crawler.loadSrtmData(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"N45E013"}, srtmData.AvailableTiles())
+	assert.Equal(t, 3, requests)
+	assert.Nil(t, crawler.saveListings(storage))
+
+	crawler = newSrtmCrawler(context.Background(), http.DefaultClient)
+	crawler.loadListings(storage)
+	recrawled, err := crawler.loadSrtmData(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, srtmData, recrawled)
+	assert.Equal(t, 6, requests)
+	assert.Equal(t, 3, notModified)
+}
+
+func TestMirrors(t *testing.T) {
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	// Serves the listings, but fails retrieving tiles:
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/SRTM3/") {
+			_, _ = w.Write([]byte(`<html><body><a href="N45E013.hgt.zip">N45E013</a></body></html>`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".hgt.zip") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`<html><body></body></html>`))
+	}))
+	defer failing.Close()
+
+	zipped := zipTile(t, "N45E013.hgt", testTileContents(100))
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(zipped)
+	}))
+	defer working.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	srtm, err := NewSrtmWithCustomStorage(http.DefaultClient, storage, WithMirrors(offline.URL, failing.URL, working.URL))
+	assert.Nil(t, err)
+	assert.Equal(t, failing.URL+"/SRTM3/", srtm.srtmData.Srtm3BaseUrl)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, 3, srtm.CacheStats().Downloads)
+}
+
+func TestCrawlTimeout(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	started := time.Now()
+	_, err = NewSrtmWithCustomStorage(http.DefaultClient, storage, WithMirrors(hung.URL, hung.URL), WithCrawlTimeout(100*time.Millisecond))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LoadSrtmDataContext(ctx, http.DefaultClient)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestParallelCrawl(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		if strings.HasSuffix(r.URL.Path, "/SRTM3/") {
+			_, _ = w.Write([]byte(`<html><body><a href="d/">d</a><a href="c/">c</a><a href="b/">b</a><a href="a/">a</a></body></html>`))
+			return
+		}
+		for _, directory := range []string{"a", "b", "c", "d"} {
+			if strings.HasSuffix(r.URL.Path, "/"+directory+"/") {
+				_, _ = w.Write([]byte(fmt.Sprintf(`<html><body><a href="N45E01%d.hgt.zip">x</a></body></html>`, strings.Index("dcba", directory))))
+				return
+			}
+		}
+		_, _ = w.Write([]byte(`<html><body></body></html>`))
+	}))
+	defer server.Close()
+
+	crawler := newSrtmCrawler(context.Background(), http.DefaultClient)
+	crawler.requests = make(chan struct{}, 2)
+	srtmData, err := crawler.loadSrtmData(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, maxInFlight)
+	names := []string{}
+	for _, srtmUrl := range srtmData.Srtm3 {
+		names = append(names, srtmUrl.Name)
+	}
+	assert.Equal(t, []string{"N45E010", "N45E011", "N45E012", "N45E013"}, names)
+}
+
+func TestIndexRefresh(t *testing.T) {
+	var lock sync.Mutex
+	listing := `<html><body><a href="N45E013.hgt.zip">N45E013</a></body></html>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/SRTM3/") {
+			_, _ = w.Write([]byte(listing))
+			return
+		}
+		_, _ = w.Write([]byte(`<html><body></body></html>`))
+	}))
+	defer server.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	srtm, err := NewSrtmWithCustomStorage(http.DefaultClient, storage, WithMirrors(server.URL), WithIndexRefresh(10*time.Millisecond))
+	assert.Nil(t, err)
+	assert.True(t, srtm.HasTile(45.5, 13.5))
+	assert.False(t, srtm.HasTile(46.5, 13.5))
+	// Not in the index, cached as a tile without data:
+	elevation, err := srtm.GetElevation(46.5, 13.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+
+	lock.Lock()
+	listing = `<html><body><a href="N45E013.hgt.zip">N45E013</a><a href="N46E013.hgt.zip">N46E013</a></body></html>`
+	lock.Unlock()
+
+	for started := time.Now(); !srtm.HasTile(46.5, 13.5); time.Sleep(5 * time.Millisecond) {
+		if time.Since(started) > 5*time.Second {
+			t.Fatal("Index not refreshed")
+		}
+	}
+	srtm.lock.Lock()
+	_, cached := srtm.cache["N46E013"]
+	srtm.lock.Unlock()
+	assert.False(t, cached)
+
+	assert.Nil(t, srtm.Close())
+	assert.Nil(t, srtm.refreshDone)
+}
+
+func TestWithSrtmData(t *testing.T) {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.zip", zipTile(t, "N45E013.hgt", testTileContents(100))))
+
+	// No mirrors online, the index must not be crawled:
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+	srtmData := &SrtmData{Srtm3BaseUrl: offline.URL + "/", Srtm3: []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}}
+	srtm, err := NewSrtmWithCustomStorage(http.DefaultClient, storage, WithMirrors(offline.URL), WithSrtmData(srtmData))
+	assert.Nil(t, err)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.False(t, srtm.HasTile(46.5, 13.5))
+
+	srtm.SetSrtmData(&SrtmData{Srtm3: []SrtmUrl{{Name: "N46E013"}}})
+	assert.Equal(t, []string{"N46E013"}, srtm.AvailableTiles())
+}
+
+func TestGetGrid(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+
+	grid, err := srtm.GetGrid(45.5, 13.5, 46, 14.75, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, grid.Rows)
+	assert.Equal(t, 5, grid.Columns)
+	latitude, longitude := grid.CellCenter(0, 0)
+	assert.InDelta(t, 45.875, latitude, 0.000001)
+	assert.InDelta(t, 13.625, longitude, 0.000001)
+	assert.Equal(t, 100.0, grid.Get(1, 1))
+	// Not available:
+	assert.True(t, math.IsNaN(grid.Get(0, 4)))
+
+	grid32, err := srtm.GetGridFloat32(45.5, 13.5, 46, 14.75, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, grid32.Rows)
+	assert.Equal(t, 5, grid32.Columns)
+	assert.Equal(t, float32(100), grid32.Get(1, 1))
+	assert.True(t, math.IsNaN(float64(grid32.Get(0, 4))))
+	latitude, longitude = grid32.CellCenter(0, 0)
+	assert.InDelta(t, 45.875, latitude, 0.000001)
+	assert.InDelta(t, 13.625, longitude, 0.000001)
+
+	_, err = srtm.GetGrid(45.5, 13.5, 46, 14, 0)
+	assert.NotNil(t, err)
+	_, err = srtm.GetGridFloat32(45.5, 13.5, 46, 14, 0)
+	assert.NotNil(t, err)
+	_, err = srtm.GetGrid(46, 13.5, 45, 14, 10)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+}
+
+func TestColorRamp(t *testing.T) {
+	ramp := ColorRamp{
+		Stops: []ColorStop{
+			{Elevation: 0, Color: color.RGBA{R: 0, G: 100, B: 0, A: 255}},
+			{Elevation: 1000, Color: color.RGBA{R: 200, G: 100, B: 50, A: 255}},
+		},
+		Void:          color.RGBA{R: 255, A: 255},
+		BelowSeaLevel: color.RGBA{B: 255, A: 255},
+	}
+	assert.Equal(t, color.RGBA{R: 100, G: 100, B: 25, A: 255}, ramp.Color(500))
+	assert.Equal(t, color.RGBA{R: 200, G: 100, B: 50, A: 255}, ramp.Color(5000))
+	assert.Equal(t, ramp.Void, ramp.Color(math.NaN()))
+	assert.Equal(t, ramp.BelowSeaLevel, ramp.Color(-1))
+
+	srtm := newTestSrtm(t, 500, "N45E013")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, srtm.WriteColorPNG(buf, 45, 13, 46, 14.5, 2, ramp))
+	img, err := png.Decode(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+	assert.Equal(t, color.RGBA{R: 100, G: 100, B: 25, A: 255}, color.RGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, ramp.Void, color.RGBAModel.Convert(img.At(2, 1)))
+}
+
+func TestTileImage(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 0, 1, -10)
+	setTestSample(contents, 1201, 1, 0, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	img, err := srtm.TileImage("N45E013")
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 1201, 1201), img.Bounds())
+	assert.Equal(t, color.Gray16{Y: 32868}, img.At(0, 0))
+	assert.Equal(t, color.Gray16{Y: 32758}, img.At(1, 0))
+	assert.Equal(t, color.Gray16{Y: TileVoidGray}, img.At(0, 1))
+
+	_, err = srtm.TileImage("N46E013")
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+	_, err = srtm.TileImage("X")
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+}
+
+func TestNormalMap(t *testing.T) {
+	// Rising to the east by 1m per meter:
+	grid := &ElevationGrid{CellSize: 0.001, Rows: 3, Columns: 3}
+	cellSize := 0.001 * earthRadius * math.Pi / 180
+	for row := 0; row < 3; row++ {
+		for column := 0; column < 3; column++ {
+			grid.Elevations = append(grid.Elevations, float64(column)*cellSize)
+		}
+	}
+
+	east, north := grid.gradient(1, 1)
+	assert.InDelta(t, 1, east, 0.001)
+	assert.InDelta(t, 0, north, 0.001)
+	// At the edge:
+	east, north = grid.gradient(0, 0)
+	assert.InDelta(t, 0.5, east, 0.001)
+	assert.InDelta(t, 0, north, 0.001)
+
+	img := grid.NormalMap(1)
+	// Normal (-1, 0, 1) / sqrt(2):
+	assert.Equal(t, color.RGBA{R: 37, G: 128, B: 218, A: 255}, img.RGBAAt(1, 1))
+	// Flat:
+	assert.Equal(t, color.RGBA{R: 128, G: 128, B: 255, A: 255}, grid.NormalMap(0).RGBAAt(1, 1))
+	grid.Elevations[4] = math.NaN()
+	assert.Equal(t, color.RGBA{R: 128, G: 128, B: 255, A: 255}, grid.NormalMap(1).RGBAAt(1, 1))
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, srtm.WriteNormalMapPNG(buf, 45.1, 13.1, 45.2, 13.2, 100, 2))
+	_, err := png.Decode(buf)
+	assert.Nil(t, err)
+}
+
+func TestExportASCIIGrid(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, srtm.ExportASCIIGrid(buf, 45.5, 13.5, 46, 14.5, 4))
+	assert.Equal(t, `ncols 4
+nrows 2
+xllcorner 13.5
+yllcorner 45.5
+cellsize 0.25
+NODATA_value -32768
+100 100 -32768 -32768
+100 100 -32768 -32768
+`, buf.String())
+}
+
+// assertWatertight checks that every edge of the mesh is shared by exactly two triangles (in opposite directions)
+func assertWatertight(t *testing.T, triangles []stlTriangle) {
+	edges := map[[2]stlVertex]int{}
+	for _, triangle := range triangles {
+		for n := 0; n < 3; n++ {
+			edges[[2]stlVertex{triangle.vertices[n], triangle.vertices[(n+1)%3]}]++
+		}
+	}
+	for edge, count := range edges {
+		assert.Equal(t, 1, count)
+		assert.Equal(t, 1, edges[[2]stlVertex{edge[1], edge[0]}])
+	}
+}
+
+func TestExportSTL(t *testing.T) {
+	grid := &ElevationGrid{MinLatitude: 45, MinLongitude: 13, CellSize: 0.01, Rows: 4, Columns: 4}
+	for n := 0; n < 16; n++ {
+		grid.Elevations = append(grid.Elevations, float64(n))
+	}
+	grid.Elevations[5] = math.NaN()
+
+	triangles, err := grid.stlTriangles(2, STLFillVoids)
+	assert.Nil(t, err)
+	// 9 cells (top and bottom) + 12 wall quads:
+	assert.Equal(t, 2*9*2+12*2, len(triangles))
+	assertWatertight(t, triangles)
+	for _, triangle := range triangles[:2] {
+		assert.InDelta(t, 1, triangle.normal[2], 0.1)
+	}
+
+	// The 4 cells around the void are skipped, 8 walls around the remaining cells and 4 around the hole:
+	triangles, err = grid.stlTriangles(2, STLSkipVoids)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*5*2+(8+4)*2, len(triangles))
+	assertWatertight(t, triangles)
+
+	_, err = (&ElevationGrid{Rows: 1, Columns: 5, Elevations: make([]float64, 5)}).stlTriangles(1, STLFillVoids)
+	assert.NotNil(t, err)
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, srtm.ExportSTL(buf, 45.1, 13.1, 45.2, 13.2, 20, 1))
+	// A single cell, 2 triangles each for the top, bottom and 4 walls:
+	assert.Equal(t, 80+4+12*50, buf.Len())
+}
+
+func TestTerrarium(t *testing.T) {
+	assert.Equal(t, color.RGBA{R: 128, G: 100, B: 128, A: 255}, encodeTerrarium(100.5))
+	assert.Equal(t, color.RGBA{R: 127, G: 246, B: 0, A: 255}, encodeTerrarium(-10))
+	assert.Equal(t, color.RGBA{R: 128, G: 0, B: 0, A: 255}, encodeTerrarium(math.NaN()))
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	// Zoom 12 tile with Rovinj (45.08, 13.64), fully in N45E013:
+	img, err := srtm.TerrariumImage(12, 2203, 1470, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), img.Bounds())
+	assert.Equal(t, color.RGBA{R: 128, G: 100, B: 0, A: 255}, img.RGBAAt(3, 3))
+
+	_, err = srtm.TerrariumImage(2, 4, 0, 256)
+	assert.NotNil(t, err)
+	assert.NotNil(t, srtm.WriteTerrariumPNG(new(bytes.Buffer), 2, 0, 0, 0))
+}
+
+func TestSimplifyProfile(t *testing.T) {
+	profile := []ProfilePoint{}
+	for n, elevation := range []float64{100, 100.5, 101, 150, 101, 100.2, math.NaN(), 99.8, 100} {
+		profile = append(profile, ProfilePoint{Distance: float64(n) * 100, Elevation: elevation})
+	}
+
+	distances := []float64{}
+	for _, point := range SimplifyProfile(profile, 2) {
+		distances = append(distances, point.Distance)
+	}
+	assert.Equal(t, []float64{0, 200, 300, 400, 800}, distances)
+
+	assert.Equal(t, 2, len(SimplifyProfile(profile, 1000)))
+	assert.Equal(t, profile[:1], SimplifyProfile(profile[:1], 2))
+	assert.Empty(t, SimplifyProfile(nil, 2))
+
+	// Without elevations at the ends, the ends are kept and the profile is simplified between the first and last
+	// known elevations:
+	profile = append([]ProfilePoint{{Distance: -100, Elevation: math.NaN()}}, profile...)
+	profile = append(profile, ProfilePoint{Distance: 900, Elevation: math.NaN()})
+	distances = []float64{}
+	for _, point := range SimplifyProfile(profile, 2) {
+		distances = append(distances, point.Distance)
+	}
+	assert.Equal(t, []float64{-100, 0, 200, 300, 400, 800, 900}, distances)
+	assert.Equal(t, 4, len(SimplifyProfile(profile, 1000)))
+	simplified := SimplifyProfile(profile[:1], 2)
+	assert.Equal(t, 1, len(simplified))
+	assert.Equal(t, -100.0, simplified[0].Distance)
+
+	// Without any elevation:
+	voids := []ProfilePoint{{Distance: 0, Elevation: math.NaN()}, {Distance: 100, Elevation: math.NaN()}, {Distance: 200, Elevation: math.NaN()}}
+	assert.Equal(t, 2, len(SimplifyProfile(voids, 2)))
+}
+
+func TestResampleTrack(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+
+	// About 1112m and 556m:
+	track := [][2]float64{{45.5, 13.5}, {45.51, 13.5}, {45.515, 13.5}}
+	points, err := srtm.ResampleTrack(track, 500)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(points))
+	for n, point := range points[:4] {
+		assert.InDelta(t, float64(n)*500, point.Distance, 0.000001)
+		assert.Equal(t, 100.0, point.Elevation)
+	}
+	assert.InDelta(t, 1000/haversineDistance(45.5, 13.5, 45.51, 13.5)*0.01+45.5, points[2].Latitude, 0.000001)
+	assert.Equal(t, 45.515, points[4].Latitude)
+	assert.InDelta(t, haversineDistance(45.5, 13.5, 45.515, 13.5), points[4].Distance, 0.01)
+
+	points, err = srtm.ResampleTrack(track[:1], 500)
+	assert.Nil(t, err)
+	assert.Equal(t, []ProfilePoint{{Latitude: 45.5, Longitude: 13.5, Elevation: 100}}, points)
+
+	// Flat, the 3D distance is the same:
+	for _, point := range points {
+		assert.InDelta(t, point.Distance, point.Distance3D, 0.000001)
+	}
+
+	_, err = srtm.ResampleTrack(track, 0)
+	assert.NotNil(t, err)
+
+	// 10m down for every row to the north:
+	contents := make([]byte, 1201*1201*2)
+	for row := 0; row < 1201; row++ {
+		for column := 0; column < 1201; column++ {
+			setTestSample(contents, 1201, row, column, int16(row*10))
+		}
+	}
+	srtm = newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+	points, err = srtm.ResampleTrack(track, 500)
+	assert.Nil(t, err)
+	slope := 10 / haversineDistance(45, 13.5, 45+1.0/1200, 13.5)
+	for _, point := range points {
+		assert.InDelta(t, point.Distance*math.Sqrt(1+slope*slope), point.Distance3D, 0.01)
+	}
+	assert.True(t, points[4].Distance3D > points[4].Distance+5)
+}
+
+func TestRegionHistogram(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, 149)
+	setTestSample(contents, 1201, 600, 601, 150)
+	setTestSample(contents, 1201, 601, 600, -1)
+	setTestSample(contents, 1201, 601, 601, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	// 25x25 samples:
+	histogram, err := srtm.RegionHistogram(45.49, 13.49, 45.51, 13.51, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]int{100: 25*25 - 3, 150: 1, -50: 1, HistogramVoids: 1}, histogram)
+
+	_, err = srtm.RegionHistogram(45.49, 13.49, 45.51, 13.51, 0)
+	assert.NotNil(t, err)
+}
+
+func TestPeaks(t *testing.T) {
+	grid := &ElevationGrid{MinLatitude: 45, MinLongitude: 13, CellSize: 1, Rows: 3, Columns: 7, Elevations: []float64{
+		10, 10, 10, 10, 10, 10, 10,
+		10, 50, 20, 30, 20, 41, 10,
+		10, 10, 10, 10, 10, math.NaN(), 10,
+	}}
+
+	peaks := grid.Peaks(1, 0)
+	assert.Equal(t, 3, len(peaks))
+	assert.Equal(t, Peak{Latitude: 46.5, Longitude: 14.5, Elevation: 50, Prominence: 40}, peaks[0])
+	assert.Equal(t, Peak{Latitude: 46.5, Longitude: 18.5, Elevation: 41, Prominence: 21}, peaks[1])
+	assert.Equal(t, Peak{Latitude: 46.5, Longitude: 16.5, Elevation: 30, Prominence: 10}, peaks[2])
+
+	assert.Equal(t, 2, len(grid.Peaks(1, 15)))
+	// 30 is less than 2 cells from 41 and 50:
+	assert.Equal(t, 2, len(grid.Peaks(2, 0)))
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	_, err := srtm.FindPeaks(45, 13, 46, 14, 10, 0, 0)
+	assert.NotNil(t, err)
+	peaks, err = srtm.FindPeaks(45, 13, 45.5, 13.5, 10, 1, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, peaks)
+}
+
+func TestPurgeStorage(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014", "N46E013")
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+
+	assert.Nil(t, srtm.PurgeStorage("N45E013", "N10E010"))
+	_, err = srtm.storage.LoadFile("N45E013.hgt.zip")
+	assert.True(t, srtm.storage.IsNotExists(err))
+	assert.NotContains(t, srtm.cache, "N45E013")
+	_, err = srtm.storage.LoadFile("N45E014.hgt.zip")
+	assert.Nil(t, err)
+
+	assert.Nil(t, srtm.PurgeAllStorage())
+	for _, name := range []string{"N45E014", "N46E013"} {
+		_, err = srtm.storage.LoadFile(name + ".hgt.zip")
+		assert.True(t, srtm.storage.IsNotExists(err))
+	}
+	// Still in the index:
+	assert.True(t, srtm.HasTile(45.5, 13.5))
+}
+
+func TestTileTTL(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(zipTile(t, "N45E013.hgt", testTileContents(200)))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	WithTileTTL(time.Hour)(srtm)
+	storage := srtm.storage.(SrtmLocalFilePathStorage)
+
+	// Not expired yet:
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, 0, requests)
+
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(storage.FilePath("N45E013.hgt.zip"), old, old))
+	srtm.ClearCache()
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, elevation)
+	assert.Equal(t, 1, requests)
+
+	// Expired, but the server is failing:
+	assert.Nil(t, os.Chtimes(storage.FilePath("N45E013.hgt.zip"), old, old))
+	srtm.ClearCache()
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, elevation)
+	assert.Equal(t, 2, requests)
+}
+
+func TestMaxStorageBytes(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014")
+	storage := srtm.storage.(SrtmListingStorage)
+	files, err := storage.List()
+	assert.Nil(t, err)
+	WithMaxStorageBytes(2 * files["N45E013.hgt.zip"])(srtm)
+
+	for _, coordinates := range [][2]float64{{45.5, 13.5}, {45.5, 14.5}} {
+		_, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.Nil(t, err)
+	}
+	srtm.ClearCache()
+
+	// Over the limit with the third one, N45E013 is the least recently loaded:
+	addTestTile(t, srtm, "N46E013", testTileContents(100))
+	_, err = srtm.GetElevation(46.5, 13.5)
+	assert.Nil(t, err)
+	for name, expected := range map[string]bool{"N45E013": false, "N45E014": true, "N46E013": true} {
+		exists, err := srtm.storage.Exists(name + ".hgt.zip")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, exists, name)
+	}
+	assert.NotContains(t, srtm.storageAccess, "N45E013")
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2020 12:00:30 GMT": 30 * time.Second,
+		"Wed, 01 Jan 2020 11:00:00 GMT": 0,
+	} {
+		wait, ok := parseRetryAfter(value, now)
+		assert.True(t, ok, value)
+		assert.Equal(t, expected, wait, value)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		_, ok := parseRetryAfter(value, now)
+		assert.False(t, ok, value)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write(zipTile(t, "N45E013.hgt", testTileContents(100)))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, 2, requests)
+}
+
+func TestRetryAfterContext(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N46E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N45E013", Url: "N45E013.hgt.zip"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	done := make(chan error)
+	go func() {
+		_, err := srtm.GetElevationContext(ctx, 45.5, 13.5)
+		done <- err
+	}()
+
+	// The wait doesn't block the queries of other tiles:
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	elevation, err := srtm.GetElevation(46.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	err = <-done
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), fmt.Sprint(err))
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Region functions (without a context to stop the wait) return the error without waiting:
+	started = time.Now()
+	_, err = srtm.RegionStats(45.1, 13.1, 45.2, 13.2)
+	assert.True(t, errors.Is(err, ErrTileNotAvailable), fmt.Sprint(err))
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/N45E013.hgt.zip":
+			http.Redirect(w, r, "/moved/N45E013.hgt.zip", http.StatusFound)
+		case "/N46E013.hgt.zip":
+			http.Redirect(w, r, "/N46E013.hgt.zip", http.StatusFound)
+		case "/N47E013.hgt.zip":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/moved/N45E013.hgt.zip", http.StatusFound)
+		case "/moved/N45E013.hgt.zip":
+			_, _ = w.Write(zipTile(t, "N45E013.hgt", testTileContents(100)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	WithMaxRedirects(2)(srtm)
+	WithSameHostRedirects()(srtm)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	for _, name := range []string{"N45E013", "N46E013", "N47E013"} {
+		srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: name, Url: name + ".hgt.zip"})
+	}
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	_, err = srtm.GetElevation(46.5, 13.5)
+	assert.True(t, errors.Is(err, ErrTooManyRedirects), "%v", err)
+
+	_, err = srtm.GetElevation(47.5, 13.5)
+	assert.True(t, errors.Is(err, ErrRedirectToOtherHost), "%v", err)
+}
+
+func TestGetElevationsStream(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	addTestTile(t, srtm, "N45E014", testTileContents(150))
+
+	in := make(chan Point, 4)
+	in <- Point{Lat: 45.5, Lon: 14.5}
+	in <- Point{Lat: 45.5, Lon: 13.5}
+	in <- Point{Lat: 45.6, Lon: 14.6}
+	in <- Point{Lat: 95, Lon: 13.5}
+	close(in)
+
+	results := map[Point]ElevationResult{}
+	for result := range srtm.GetElevationsStream(context.Background(), in) {
+		results[result.Point] = result
+	}
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, 150.0, results[Point{Lat: 45.5, Lon: 14.5}].Elevation)
+	assert.Equal(t, 100.0, results[Point{Lat: 45.5, Lon: 13.5}].Elevation)
+	assert.Equal(t, 150.0, results[Point{Lat: 45.6, Lon: 14.6}].Elevation)
+	assert.True(t, errors.Is(results[Point{Lat: 95, Lon: 13.5}].Err, ErrInvalidCoordinate))
+
+	// Cancelled while waiting for points:
+	ctx, cancel := context.WithCancel(context.Background())
+	out := srtm.GetElevationsStream(ctx, make(chan Point))
+	cancel()
+	_, ok := <-out
+	assert.False(t, ok)
+
+	// Cancelled while downloading a tile:
+	requested := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+	ctx, cancel = context.WithCancel(context.Background())
+	in = make(chan Point, 1)
+	in <- Point{Lat: 46.5, Lon: 13.5}
+	out = srtm.GetElevationsStream(ctx, in)
+	<-requested
+	cancel()
+	select {
+	case result, ok := <-out:
+		if ok {
+			assert.True(t, errors.Is(result.Err, context.Canceled), fmt.Sprint(result.Err))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download not cancelled")
+	}
+}
+
+func TestGetTileGrid(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 0, 1, -5)
+	setTestSample(contents, 1201, 1200, 1200, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	samples, size, err := srtm.GetTileGrid(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1201, size)
+	assert.Equal(t, 1201*1201, len(samples))
+	assert.Equal(t, int16(100), samples[0])
+	assert.Equal(t, int16(-5), samples[1])
+	assert.Equal(t, int16(voidValue), samples[len(samples)-1])
+
+	count := 0
+	err = srtm.ForEachSample(45.5, 13.5, func(sampleLat, sampleLon float64, elevation int16) {
+		switch count {
+		case 0:
+			assert.Equal(t, 46.0, sampleLat)
+			assert.Equal(t, 13.0, sampleLon)
+			assert.Equal(t, int16(100), elevation)
+		case 1:
+			assert.InDelta(t, 13+1/1200.0, sampleLon, 0.0000001)
+			assert.Equal(t, int16(-5), elevation)
+		case 1201*1201 - 1:
+			assert.Equal(t, 45.0, sampleLat)
+			assert.Equal(t, 14.0, sampleLon)
+			assert.Equal(t, int16(voidValue), elevation)
+		}
+		count++
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1201*1201, count)
+	assert.True(t, errors.Is(srtm.ForEachSample(44.5, 13.5, func(float64, float64, int16) {}), ErrTileNotAvailable))
+
+	_, _, err = srtm.GetTileGrid(44.5, 13.5)
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+	_, _, err = srtm.GetTileGrid(95, 13.5)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+}
+
+func TestTileFileNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(zipTile(t, "N45E013.hgt", testTileContents(100)))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	WithTileFileNames(ResolutionTileFileNames)(srtm)
+	// Flat files are ignored:
+	assert.Nil(t, srtm.storage.SaveFile("N45E013.hgt.zip", zipTile(t, "N45E013.hgt", testTileContents(200))))
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	storage := srtm.storage.(*LocalFileSrtmStorage)
+	files, err := storage.List()
+	assert.Nil(t, err)
+	assert.Contains(t, files, "srtm3/N45E013.hgt.zip")
+	assert.Contains(t, files, "N45E013.hgt.zip")
+
+	assert.Nil(t, srtm.PurgeStorage("N45E013"))
+	exists, err := storage.Exists("srtm3/N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestSharedStorageLocking(t *testing.T) {
+	var requests int32
+	tile := zipTile(t, "N45E013.hgt", testTileContents(100))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write(tile)
+	}))
+	defer server.Close()
+
+	first := newTestSrtm(t, 0)
+	second := newTestSrtm(t, 0)
+	second.storage = first.storage
+	var wg sync.WaitGroup
+	for _, srtm := range []*Srtm{first, second} {
+		srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+		srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+		wg.Add(1)
+		go func(srtm *Srtm) {
+			defer wg.Done()
+			elevation, err := srtm.GetElevation(45.5, 13.5)
+			assert.Nil(t, err)
+			assert.Equal(t, 100.0, elevation)
+		}(srtm)
+	}
+	wg.Wait()
+
+	// The second one waits for the first one to save the file:
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Empty(t, storageFileLocks.mutexes)
+}
+
+func TestAtomicSaveFile(t *testing.T) {
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, storage.SaveFile("N45E013.hgt.zip", []byte("old")))
+
+	defer func(original func(f *os.File, bytes []byte) error) { writeStorageFile = original }(writeStorageFile)
+	writeStorageFile = func(f *os.File, bytes []byte) error {
+		_, _ = f.Write(bytes[:len(bytes)/2])
+		return errors.New("Disk full")
+	}
+	assert.NotNil(t, storage.SaveFile("N45E013.hgt.zip", []byte("new contents")))
+	assert.NotNil(t, storage.SaveFile("N46E013.hgt.zip", []byte("new contents")))
+
+	bytes, err := storage.LoadFile("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(bytes))
+	_, err = storage.LoadFile("N46E013.hgt.zip")
+	assert.True(t, storage.IsNotExists(err))
+	// No temporary files left:
+	files, err := storage.List()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"N45E013.hgt.zip": 3}, files)
+}
+
+func TestFallbackStorage(t *testing.T) {
+	// Can't be created, the parent is a file:
+	notDirectory := t.TempDir() + "/file"
+	assert.Nil(t, os.WriteFile(notDirectory, nil, 0600))
+	writable := t.TempDir()
+
+	storage := NewFallbackSrtmStorage(notDirectory+"/cache", writable)
+	assert.Equal(t, writable, storage.(*LocalFileSrtmStorage).cacheDirectory)
+	exists, err := storage.Exists(writeTestFileName)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	memory, ok := NewFallbackSrtmStorage().(*MemorySrtmStorage)
+	assert.True(t, ok)
+	_, err = memory.LoadFile("N45E013.hgt.zip")
+	assert.True(t, memory.IsNotExists(err))
+	assert.Nil(t, memory.SaveFile("N45E013.hgt.zip", []byte("zip")))
+	bytes, err := memory.LoadFile("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.Equal(t, "zip", string(bytes))
+	files, err := memory.List()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"N45E013.hgt.zip": 3}, files)
+	assert.Nil(t, memory.Delete("N45E013.hgt.zip"))
+	exists, err = memory.Exists("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestDefaultCacheDirectory(t *testing.T) {
+	home, cache := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", cache)
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG_CACHE_HOME is used only on Linux")
+	}
+
+	storage, err := NewLocalFileSrtmStorage("")
+	assert.Nil(t, err)
+	assert.Equal(t, cache+"/go-elevations", storage.cacheDirectory)
+
+	assert.Nil(t, os.Mkdir(home+"/.geoelevations", 0700))
+	assert.Equal(t, home+"/.geoelevations", DefaultCacheDirectory())
+}
+
+func TestGetSrtmUrl(t *testing.T) {
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData = SrtmData{
+		Srtm1BaseUrl: "http://srtm1/",
+		Srtm1:        []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}},
+		Srtm3BaseUrl: "http://srtm3/",
+		Srtm3:        []SrtmUrl{{Name: "N45E013", Url: "Eurasia/N45E013.hgt.zip"}, {Name: "N46E013", Url: "Eurasia/N46E013.hgt.zip"}},
+	}
+
+	baseUrl, srtmUrl := srtm.GetSrtmUrl("N45E013", SRTM1)
+	assert.Equal(t, "http://srtm1/", baseUrl)
+	assert.Equal(t, "N45E013.hgt.zip", srtmUrl.Url)
+	baseUrl, srtmUrl = srtm.GetSrtmUrl("N45E013", SRTM3)
+	assert.Equal(t, "http://srtm3/", baseUrl)
+	assert.Equal(t, "Eurasia/N45E013.hgt.zip", srtmUrl.Url)
+
+	_, srtmUrl = srtm.GetSrtmUrl("N46E013", SRTM1)
+	assert.Nil(t, srtmUrl)
+	_, srtmUrl = srtm.GetSrtmUrl("N45E013", SrtmResolution(2))
+	assert.Nil(t, srtmUrl)
+}
+
+func TestResolutionPreference(t *testing.T) {
+	srtmData := SrtmData{
+		Srtm1BaseUrl: "http://srtm1/",
+		Srtm1:        []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}},
+		Srtm3BaseUrl: "http://srtm3/",
+		Srtm3:        []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}, {Name: "N46E013", Url: "N46E013.hgt.zip"}},
+	}
+
+	for i := 0; i < 3; i++ {
+		baseUrl, _ := srtmData.GetBestSrtmUrl("N45E013")
+		assert.Equal(t, "http://srtm1/", baseUrl)
+	}
+
+	for _, test := range []struct {
+		preference []SrtmResolution
+		tile       string
+		expected   string
+	}{
+		{nil, "N45E013", "http://srtm1/N45E013.hgt.zip"},
+		{nil, "N46E013", "http://srtm3/N46E013.hgt.zip"},
+		{[]SrtmResolution{SRTM3, SRTM1}, "N45E013", "http://srtm3/N45E013.hgt.zip"},
+		{[]SrtmResolution{SRTM1}, "N46E013", ""},
+	} {
+		srtm := newTestSrtm(t, 0)
+		srtm.srtmData = srtmData
+		WithResolutionPreference(test.preference...)(srtm)
+		latitude, longitude, _, _, _ := TileBounds(test.tile)
+		srtmFile := srtm.getSrtmFile(test.tile, latitude, longitude)
+		if test.expected == "" {
+			assert.False(t, srtmFile.isValidSrtmFile)
+			continue
+		}
+		assert.Equal(t, test.expected, srtmFile.fileUrl, "%v %s", test.preference, test.tile)
+	}
+}
+
+func TestNoCoverage(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N65E013")
+	WithMissingTileAsSeaLevel()(srtm)
+
+	for _, coordinates := range [][2]float64{{60, 13.5}, {75, 13}, {-56.5, 13}, {90, 0}} {
+		elevation, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.True(t, errors.Is(err, ErrNoCoverage), "%v", coordinates)
+		assert.True(t, math.IsNaN(elevation))
+	}
+
+	// Tiles in the index are used anyway:
+	elevation, err := srtm.GetElevation(65.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	elevation, err = srtm.GetElevation(59.9, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, elevation)
+
+	// Areas which extend beyond the coverage:
+	grid, err := srtm.GetGrid(59.5, 13, 60.5, 13.5, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, grid.Get(1, 0))
+	assert.True(t, math.IsNaN(grid.Get(0, 0)))
+
+	// Missing neighbours (for the interpolation) are sea level only within the coverage:
+	srtm = newTestSrtm(t, 100, "N59E013")
+	WithMissingTileAsSeaLevel()(srtm)
+	_, err = srtm.GetElevation(59.5, 13.5)
+	assert.Nil(t, err)
+	srtmFile := srtm.cache["N59E013"]
+	assert.True(t, math.IsNaN(srtm.getSample(nil, srtmFile, -1, 600)))
+	assert.Equal(t, 0.0, srtm.getSample(nil, srtmFile, 1201, 600))
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	// Uncompressed tiles, prefixed with "RAW":
+	RegisterDecompressor(".RAW", DecompressorFunc(func(compressed []byte) ([]byte, error) {
+		if !bytes.HasPrefix(compressed, []byte("RAW")) {
+			return nil, errors.New("Not a raw tile")
+		}
+		return compressed[3:], nil
+	}))
+	defer func() {
+		decompressorsLock.Lock()
+		delete(decompressors, ".raw")
+		decompressorsLock.Unlock()
+	}()
+	assert.Equal(t, []string{".zip", ".gz", ".raw"}, compressedExtensions())
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write(append([]byte("RAW"), testTileContents(100)...))
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.raw"}}
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	exists, err := srtm.storage.Exists("N45E013.hgt.raw")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// From the storage:
+	srtm.ClearCache()
+	elevation, err = srtm.GetElevation(45.6, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Zipped files are recognized by their contents:
+	decompressed, err := decompressTile("N45E013.hgt.raw", zipTile(t, "N45E013.hgt", []byte("hgt")))
+	assert.Nil(t, err)
+	assert.Equal(t, "hgt", string(decompressed))
+	_, err = decompressTile("N45E013.hgt.bz2", []byte("hgt"))
+	assert.NotNil(t, err)
+}
+
+// quarterTileScheme has 0.25 degree tiles named like "Q180_52"
+type quarterTileScheme struct{}
+
+func (self quarterTileScheme) Tile(latitude, longitude float64) (string, float64, float64) {
+	row, column := math.Floor(latitude*4), math.Floor(longitude*4)
+	return fmt.Sprintf("Q%d_%d", int(row), int(column)), row / 4, column / 4
+}
+
+func (self quarterTileScheme) Position(minLatitude, minLongitude float64, squareSize int, latitude, longitude float64) (float64, float64) {
+	return (minLatitude + 0.25 - latitude) * 4 * float64(squareSize-1), (longitude - minLongitude) * 4 * float64(squareSize-1)
+}
+
+func TestTileScheme(t *testing.T) {
+	contents := testTileContents(100)
+	// South-east quarter of the tile:
+	for row := 600; row < 1201; row++ {
+		for column := 600; column < 1201; column++ {
+			setTestSample(contents, 1201, row, column, 200)
+		}
+	}
+	srtm := newTestSrtm(t, 0)
+	WithTileScheme(quarterTileScheme{})(srtm)
+	addTestTile(t, srtm, "Q181_53", contents)
+
+	elevation, err := srtm.GetElevation(45.26, 13.26)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	elevation, err = srtm.GetElevation(45.251, 13.49)
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, elevation)
+	assert.True(t, srtm.HasTile(45.3, 13.3))
+	assert.False(t, srtm.HasTile(45.6, 13.3))
+	_, _, err = srtm.GetTileGrid(45.6, 13.3)
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+	assert.Contains(t, err.Error(), "Q182_53")
+
+	// Streamed points are grouped by the tiles of the scheme:
+	in := make(chan Point, 3)
+	in <- Point{Lat: 45.26, Lon: 13.26}
+	in <- Point{Lat: 45.6, Lon: 13.3}
+	in <- Point{Lat: 45.3, Lon: 13.3}
+	close(in)
+	points := []Point{}
+	for result := range srtm.GetElevationsStream(context.Background(), in) {
+		points = append(points, result.Point)
+	}
+	assert.Equal(t, []Point{{Lat: 45.26, Lon: 13.26}, {Lat: 45.3, Lon: 13.3}, {Lat: 45.6, Lon: 13.3}}, points)
+}
+
+func TestBicubicInterpolation(t *testing.T) {
+	contents := testTileContents(5000)
+	for row := 0; row < 1201; row++ {
+		for column := 530; column <= 670; column++ {
+			setTestSample(contents, 1201, row, column, int16((column-600)*(column-600)))
+		}
+	}
+	setTestSample(contents, 1201, 600, 603, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+	WithInterpolation(InterpolationBicubic)(srtm)
+
+	// The parabola, instead of the bilinear 0.5:
+	elevation, err := srtm.GetElevation(45.75, 13.0+600.5/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.25, elevation, 0.0001)
+
+	// Bilinear near voids and on the tile edge:
+	elevation, err = srtm.GetElevation(46-600.5/1200, 13.0+601.5/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 2.5, elevation, 0.0001)
+	elevation, err = srtm.GetElevation(45.5, 13.0+0.5/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 5000, elevation, 0.0001)
+}
+
+func TestStrings(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm1BaseUrl = "http://localhost/srtm1/"
+	assert.Equal(t, "SRTM1: 0 tiles at http://localhost/srtm1/, SRTM3: 1 tiles at http://localhost/", srtm.srtmData.String())
+
+	srtmFile := srtm.getSrtmFile("N45E013", 45, 13)
+	assert.Equal(t, "N45E013 (45,13 - 46,14, squareSize=0, not loaded, 0 bytes)", srtmFile.String())
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, "N45E013 (45,13 - 46,14, squareSize=1201, loaded, 2884802 bytes)", fmt.Sprint(srtmFile))
+	assert.Equal(t, "S01W001 (-1,-1 - 0,0, squareSize=0, not available, 0 bytes)", srtm.getSrtmFile("S01W001", -1, -1).String())
+}
+
+func TestSrtmDataJSON(t *testing.T) {
+	srtmData := &SrtmData{
+		Srtm1BaseUrl: "http://srtm1/",
+		Srtm1:        []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip", Sha256: "abcd"}},
+		Srtm3BaseUrl: "http://srtm3/",
+		Srtm3:        []SrtmUrl{{Name: "N45E013", Url: "Eurasia/N45E013.hgt.zip"}, {Name: "N46E013", Url: "Eurasia/N46E013.hgt.zip"}},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, srtmData.WriteJSON(&buf))
+	assert.Contains(t, buf.String(), `"srtm2":`)
+	assert.NotContains(t, buf.String(), `"s":""`)
+
+	read, err := ReadSrtmData(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, srtmData, read)
+
+	_, err = ReadSrtmData(strings.NewReader("{"))
+	assert.NotNil(t, err)
+}
+
+func BenchmarkDecompressTile(b *testing.B) {
+	contents := testTileContents(100)
+	zipped := zipTile(b, "N45E013.hgt", contents)
+	gzipped, err := gzipBytes(&contents)
+	assert.Nil(b, err)
+
+	for _, compressed := range []struct {
+		extension string
+		bytes     []byte
+	}{{".zip", zipped}, {".gz", *gzipped}} {
+		b.Run(compressed.extension, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decompressTile("N45E013.hgt"+compressed.extension, compressed.bytes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	// Not presized (read with ioutil.ReadAll), for comparison:
+	b.Run(".zip-readall", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			rc, err := r.File[0].Open()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := ioutil.ReadAll(rc); err != nil {
+				b.Fatal(err)
+			}
+			rc.Close()
+		}
+	})
+	b.Run(".gz-readall", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r, err := gzip.NewReader(bytes.NewReader(*gzipped))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := ioutil.ReadAll(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestReadResponseBody(t *testing.T) {
+	contents := testTileContents(100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// Without Content-Length:
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(contents)))
+		}
+		_, _ = w.Write(contents)
+	}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/N45E013.hgt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(contents)), response.ContentLength)
+	body, err := readResponseBody(response)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, contents, body)
+	// Presized, never grown (the allocation is only rounded up to whole pages):
+	assert.True(t, cap(body) <= len(contents)+bytes.MinRead+8192, cap(body))
+
+	response, err = http.Get(server.URL + "/chunked")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), response.ContentLength)
+	body, err = readResponseBody(response)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, contents, body)
+}
+
+func TestLastTileFastPath(t *testing.T) {
+	srtm := newTestSrtm(t, 100, "N45E013", "N45E014")
+
+	for _, coordinates := range [][2]float64{{45.5, 13.5}, {45.9, 13.999}, {45.5, 14.0}, {45.0, 14.5}, {45.5, 13.5}} {
+		elevation, err := srtm.GetElevation(coordinates[0], coordinates[1])
+		assert.Nil(t, err)
+		assert.Equal(t, 100.0, elevation)
+		name, _, _ := getTileNameAndCoordinates(coordinates[0], coordinates[1])
+		assert.Equal(t, name, srtm.lastSrtmFile.name)
+	}
+	// The last tile must never be used after it was removed from the cache:
+	assert.Nil(t, srtm.PurgeStorage("N45E013"))
+	assert.Nil(t, srtm.lastSrtmFile)
+	addTestTile(t, srtm, "N45E013", testTileContents(200))
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 200.0, elevation)
+	assert.Same(t, srtm.cache["N45E013"], srtm.lastSrtmFile)
+
+	assert.False(t, (&SrtmFile{latitude: 89, longitude: 0}).containsPoint(88.99, 0.5))
+	assert.True(t, (&SrtmFile{latitude: 89, longitude: 0}).containsPoint(90, 0.5))
+}
+
+func BenchmarkGetElevation(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srtm := newTestSrtm(b, 100, "N45E013", "N45E014")
+	for _, benchmark := range []struct {
+		name string
+		// longitudes of consecutive queries
+		longitudes []float64
+	}{
+		{"same tile", []float64{13.25, 13.5}},
+		// Every query in another tile, as without the fast path:
+		{"alternating tiles", []float64{13.5, 14.5}},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := srtm.GetElevation(45.5, benchmark.longitudes[i%2]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadingWithoutLock(t *testing.T) {
+	zipped := zipTile(t, "N46E013.hgt", testTileContents(200))
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		_, _ = w.Write(zipped)
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			elevation, err := srtm.GetElevation(46.5, 13.5)
+			assert.Nil(t, err)
+			assert.Equal(t, 200.0, elevation)
+		}()
+	}
+
+	// While N46E013 is downloading, the loaded tile can still be queried:
+	for start := time.Now(); atomic.LoadInt32(&requests) == 0; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("N46E013 not requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	elevation, err := srtm.GetElevation(45.6, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	close(release)
+	wg.Wait()
+	// Only one download for the concurrent queries:
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, srtm.CacheStats().Downloads)
+}
+
+func TestRegionLoadingWithoutLock(t *testing.T) {
+	zipped := zipTile(t, "N46E013.hgt", testTileContents(200))
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		_, _ = w.Write(zipped)
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+	_, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stats, err := srtm.RegionStats(46.2, 13.2, 46.3, 13.3)
+		assert.Nil(t, err)
+		assert.Equal(t, 200.0, stats.Mean)
+	}()
+	go func() {
+		defer wg.Done()
+		elevation, err := srtm.GetElevation(46.5, 13.5)
+		assert.Nil(t, err)
+		assert.Equal(t, 200.0, elevation)
+	}()
+
+	// While the region query downloads N46E013, the loaded tile can still be queried:
+	for start := time.Now(); atomic.LoadInt32(&requests) == 0; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("N46E013 not requested")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	elevation, err := srtm.GetElevation(45.6, 13.6)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	stats, err := srtm.RegionStats(45.2, 13.2, 45.3, 13.3)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, stats.Mean)
+
+	close(release)
+	wg.Wait()
+	// Only one download for the region and point queries:
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, srtm.CacheStats().Downloads)
+}
+
+func TestLittleEndianTiles(t *testing.T) {
+	// Byte swapped samples of 258 (0x0102), read as big-endian they're 513 (0x0201):
+	contents := testTileContents(0x0201)
+	// A little-endian void (0x8000):
+	setTestSample(contents, 1201, 0, 0, 0x0080)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+	WithLittleEndianTiles()(srtm)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 258.0, elevation)
+
+	srtmFile := srtm.cache["N45E013"]
+	assert.Equal(t, int16(258), srtmFile.getSample(1))
+	assert.True(t, math.IsNaN(srtmFile.getElevationFromRowAndColumn(0, 0)))
+	assert.Equal(t, 258.0, srtmFile.meanElevation())
+	assert.Equal(t, color.Gray16{Y: uint16(258 + 32768)}, srtmFile.Image().At(1, 0))
+}
+
+func TestGetElevationInt(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, 101)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+	WithInterpolation(InterpolationBilinear)(srtm)
+
+	// Halfway between 100 and 101:
+	elevation, ok := srtm.GetElevationInt(45.5, 13.5+0.5/1200)
+	assert.True(t, ok)
+	assert.Equal(t, 101, elevation)
+
+	_, ok = srtm.GetElevationInt(46.5, 13.5)
+	assert.False(t, ok)
+	_, ok = srtm.GetElevationInt(95, 13.5)
+	assert.False(t, ok)
+}
+
+func TestGetElevationsPartialResults(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 100, "N45E013")
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: "N46E013", Url: "N46E013.hgt.zip"})
+
+	elevations, err := srtm.GetElevations([][2]float64{{45.5, 13.5}, {46.5, 13.5}, {46.6, 13.6}, {95, 13}, {45.6, 13.6}})
+	assert.Equal(t, 5, len(elevations))
+	assert.Equal(t, 100.0, elevations[0])
+	assert.True(t, math.IsNaN(elevations[1]))
+	assert.True(t, math.IsNaN(elevations[2]))
+	assert.True(t, math.IsNaN(elevations[3]))
+	assert.Equal(t, 100.0, elevations[4])
+	// The failed tile isn't retried for the other points in it:
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	var elevationsErr *ElevationsError
+	assert.True(t, errors.As(err, &elevationsErr))
+	assert.Equal(t, 2, len(elevationsErr.Errors))
+	assert.True(t, errors.Is(elevationsErr.Errors["N46E013"], ErrTileNotAvailable))
+	assert.True(t, errors.Is(elevationsErr.Errors["95,13"], ErrInvalidCoordinate))
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+	assert.True(t, strings.HasPrefix(err.Error(), "Error in 2 tiles: 95,13: "), err.Error())
+
+	elevations, err = srtm.GetElevations([][2]float64{{45.5, 13.5}})
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{100}, elevations)
+}
+
+func TestInvalidZipContents(t *testing.T) {
+	_, err := decompressTile("N45E013.hgt.zip", []byte("<html><body>Quota exceeded</body></html>"))
+	assert.Equal(t, `N45E013.hgt.zip is not a .zip file, starts with "<html><body>Quot"...`, err.Error())
+	_, err = decompressTile("N45E013.hgt.gz", nil)
+	assert.Equal(t, "N45E013.hgt.gz is not a .gz file, starts with nothing (empty file)", err.Error())
+
+	srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	err = srtmFile.setContents([]byte("garbage"))
+	assert.True(t, errors.Is(err, ErrCorruptTile))
+	assert.Contains(t, err.Error(), `starts with "garbage"`)
+
+	// A corrupt cached file is retrieved again:
+	zipped := zipTile(t, "N45E013.hgt", testTileContents(100))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(zipped)
+	}))
+	defer server.Close()
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+	assert.Nil(t, srtm.storage.SaveFile("N45E013.hgt.zip", []byte("garbage")))
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	cached, err := srtm.storage.LoadFile("N45E013.hgt.zip")
+	assert.Nil(t, err)
+	assert.Equal(t, zipped, cached)
+}
+
+func TestTileSizes(t *testing.T) {
+	zipped := zipTile(t, "N45E013.hgt", testTileContents(100))
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "N46E013") && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case strings.Contains(r.URL.Path, "N47E013"):
+			http.NotFound(w, r)
+		default:
+			if r.Method == http.MethodGet {
+				atomic.AddInt32(&gets, 1)
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(zipped)))
+			_, _ = w.Write(zipped)
+		}
+	}))
+	defer server.Close()
+
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	for _, name := range []string{"N45E013", "N46E013", "N47E013"} {
+		srtm.srtmData.Srtm3 = append(srtm.srtmData.Srtm3, SrtmUrl{Name: name, Url: name + ".hgt.zip"})
+	}
+
+	sizes, err := srtm.TileSizes("N45E013", "N46E013", "N47E013", "N48E013")
+	assert.Equal(t, map[string]int64{"N45E013": int64(len(zipped)), "N46E013": int64(len(zipped))}, sizes)
+	// Only N46E013 (without HEAD support) with GET:
+	assert.Equal(t, int32(1), atomic.LoadInt32(&gets))
+
+	var sizesErr *ElevationsError
+	assert.True(t, errors.As(err, &sizesErr))
+	assert.Equal(t, 2, len(sizesErr.Errors))
+	assert.True(t, errors.Is(sizesErr.Errors["N47E013"], ErrTileNotAvailable))
+	assert.True(t, errors.Is(sizesErr.Errors["N48E013"], ErrTileNotAvailable))
+	// Nothing downloaded:
+	assert.Equal(t, 0, srtm.CacheStats().Downloads)
+}
+
+func TestDensify(t *testing.T) {
+	from, to := Point{Lat: 45, Lon: 13}, Point{Lat: 45, Lon: 13.01}
+	distance := haversineDistance(from.Lat, from.Lon, to.Lat, to.Lon)
+
+	points := Densify(from, to, 100)
+	assert.Equal(t, int(math.Ceil(distance/100))+1, len(points))
+	assert.Equal(t, from, points[0])
+	assert.Equal(t, to, points[len(points)-1])
+	for n := 1; n < len(points); n++ {
+		spacing := haversineDistance(points[n-1].Lat, points[n-1].Lon, points[n].Lat, points[n].Lon)
+		assert.True(t, spacing <= 100, spacing)
+		assert.InDelta(t, distance/float64(len(points)-1), spacing, 1e-3)
+	}
+
+	// Great circle, not a straight line in degrees (north of the straight line between points on the same parallel):
+	points = Densify(Point{Lat: 50, Lon: 0}, Point{Lat: 50, Lon: 60}, 1000000)
+	assert.True(t, points[len(points)/2].Lat > 50, points[len(points)/2].Lat)
+
+	assert.Equal(t, []Point{from, to}, Densify(from, to, distance+1))
+	assert.Equal(t, []Point{from, to}, Densify(from, to, 0))
+	assert.Equal(t, []Point{from, from}, Densify(from, from, 10))
+}
+
+func TestTileVoidFraction(t *testing.T) {
+	contents := testTileContents(100)
+	for column := 0; column < 1201; column++ {
+		setTestSample(contents, 1201, 10, column, voidValue)
+	}
+	setTestSample(contents, 1201, 20, 20, 9500)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	fraction, err := srtm.TileVoidFraction(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1202.0/(1201*1201), fraction, 1e-12)
+
+	_, err = srtm.TileVoidFraction(46.5, 13.5)
+	assert.True(t, errors.Is(err, ErrTileNotAvailable))
+	_, err = srtm.TileVoidFraction(95, 13.5)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+}
+
+func TestRegionRuggedness(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, 180)
+	setTestSample(contents, 1201, 600, 602, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	// Only the spike ((180-100)*8/8) and its neighbours (with 80, the void and 100s):
+	tri, err := srtm.RegionRuggedness(45.5, 13.5, 45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 80.0, tri)
+	tri, err = srtm.RegionRuggedness(45.5, 13.5+1.0/1200, 45.5, 13.5+1.0/1200)
+	assert.Nil(t, err)
+	assert.InDelta(t, 80.0/7, tri, 1e-9)
+
+	tri, err = srtm.RegionRuggedness(45.4, 13.4, 45.45, 13.45)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, tri)
+
+	// Flat up to the edges of the tile (the neighbouring tiles are missing):
+	tri, err = srtm.RegionRuggedness(45, 13, 46, 14)
+	assert.Nil(t, err)
+	assert.True(t, tri > 0 && tri < 0.01, tri)
+
+	tri, err = srtm.RegionRuggedness(46.1, 13.1, 46.2, 13.2)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(tri))
+}
+
+func TestRegionVolume(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, 130)
+	setTestSample(contents, 1201, 600, 601, voidValue)
+	srtm := newTestSrtm(t, 0)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	// 3x3 samples, one 30m above the others and one void:
+	cell := 1.0 / 1200
+	area := cellArea(45.5, cell)
+	stats, err := srtm.RegionVolume(45.5-cell, 13.5-cell, 45.5+cell, 13.5+cell, 110)
+	assert.Nil(t, err)
+	assert.InDelta(t, area*20, stats.Above, 1)
+	assert.InDelta(t, 7*area*10, stats.Below, 10)
+	assert.Equal(t, 8, stats.Count)
+	assert.Equal(t, 1, stats.Voids)
+
+	// A cell at 45° is about 92.6m by 65.5m:
+	assert.InDelta(t, 92.66*65.52, cellArea(45, cell), 1)
+	// The whole sphere:
+	assert.InDelta(t, 4*math.Pi*earthRadius*earthRadius, 2*cellArea(0, 180), 1)
+
+	stats, err = srtm.RegionVolume(45.1, 13.1, 45.2, 13.2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, stats.Above)
+	assert.Equal(t, 0.0, stats.Below)
+	assert.Equal(t, 0, stats.Voids)
+}
+
+func TestCrawlDepth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/SRTM3/"):
+			_, _ = w.Write([]byte(`<html><body><a href="N44E013.hgt.zip">x</a><a href="a/">a</a></body></html>`))
+		case strings.HasSuffix(r.URL.Path, "/a/"):
+			_, _ = w.Write([]byte(`<html><body><a href="N45E013.hgt.zip">x</a><a href="b/">b</a></body></html>`))
+		case strings.HasSuffix(r.URL.Path, "/b/"):
+			_, _ = w.Write([]byte(`<html><body><a href="N46E013.hgt.zip">x</a></body></html>`))
+		default:
+			_, _ = w.Write([]byte(`<html><body></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		options  []SrtmOption
+		expected []string
+	}{
+		{nil, []string{"N44E013", "N45E013"}},
+		{[]SrtmOption{WithCrawlDepth(0)}, []string{"N44E013"}},
+		{[]SrtmOption{WithCrawlDepth(2), WithCrawlConcurrency(1)}, []string{"N44E013", "N45E013", "N46E013"}},
+	} {
+		srtm := &Srtm{client: http.DefaultClient}
+		for _, option := range test.options {
+			option(srtm)
+		}
+		srtmData, err := srtm.newCrawler(context.Background()).loadSrtmData(server.URL)
+		assert.Nil(t, err)
+		names := []string{}
+		for _, srtmUrl := range srtmData.Srtm3 {
+			names = append(names, srtmUrl.Name)
+		}
+		assert.Equal(t, test.expected, names)
+	}
+}
+
+func TestGzipContentEncoding(t *testing.T) {
+	contents := testTileContents(100)
+	zipped := zipTile(t, "N45E013.hgt", contents)
+	gzipped, err := gzipBytes(&contents)
+	assert.Nil(t, err)
+	gzipEncoded := func(b []byte) []byte {
+		encoded, err := gzipBytes(&b)
+		assert.Nil(t, err)
+		return *encoded
+	}
+
+	var acceptEncodings []string
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+		lock.Unlock()
+		// Always gzip encoded, whatever the client accepts:
+		w.Header().Set("Content-Encoding", "gzip")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/SRTM3/"):
+			_, _ = w.Write(gzipEncoded([]byte(`<html><body><a href="N45E013.hgt.zip">x</a><a href="N46E013.hgt.gz">x</a></body></html>`)))
+		case strings.HasSuffix(r.URL.Path, "N45E013.hgt.zip"):
+			_, _ = w.Write(gzipEncoded(zipped))
+		case strings.HasSuffix(r.URL.Path, "N46E013.hgt.gz"):
+			// The .hgt.gz file as it is, with the encoding of the .gz extension:
+			_, _ = w.Write(*gzipped)
+		default:
+			_, _ = w.Write(gzipEncoded([]byte(`<html><body></body></html>`)))
+		}
+	}))
+	defer server.Close()
+
+	// Without the transparent decoding of the transport:
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	srtmData, err := newSrtmCrawler(context.Background(), client).loadSrtmData(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(srtmData.Srtm3))
+	assert.Equal(t, "N45E013", srtmData.Srtm3[0].Name)
+	assert.Equal(t, "N46E013", srtmData.Srtm3[1].Name)
+
+	for _, c := range []*http.Client{client, http.DefaultClient} {
+		for _, name := range []string{"N45E013", "N46E013"} {
+			srtm := newTestSrtm(t, 0)
+			srtm.client = c
+			srtm.srtmData = *srtmData
+			latitude := 45.5
+			if name == "N46E013" {
+				latitude = 46.5
+			}
+			elevation, err := srtm.GetElevation(latitude, 13.5)
+			assert.Nil(t, err, name)
+			assert.Equal(t, 100.0, elevation, name)
+		}
+	}
+	assert.Contains(t, acceptEncodings, "identity")
+}
+
+func TestGetLinksFromSloppyHtml(t *testing.T) {
+	// Unclosed tags, bare "&", attributes without quotes and upper case:
+	document := `<html><head><title>Index of /SRTM3 & more</title></head><body><p>Tiles<br>
+<A HREF="N45E013.hgt.zip">N45E013</a> <img src=icon.gif>
+<a href=N46E013.hgt.zip>N46E013
+<a class="dir" href=" Eurasia/ ">Eurasia/</a><a href="a&amp;b/">a&b</a>
+</body>`
+	assert.Equal(t, []string{"N45E013.hgt.zip", "N46E013.hgt.zip", "Eurasia/", "a&b/"}, getLinksFromHtmlDocument(strings.NewReader(document)))
+	assert.Empty(t, getLinksFromHtmlDocument(strings.NewReader("")))
+}
+
+func TestCrawlResolutions(t *testing.T) {
+	var lock sync.Mutex
+	requested := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requested[r.URL.Path] = true
+		lock.Unlock()
+		_, _ = w.Write([]byte(`<html><body><a href="N45E013.hgt.zip">x</a></body></html>`))
+	}))
+	defer server.Close()
+
+	storage, err := NewLocalFileSrtmStorage(t.TempDir())
+	assert.Nil(t, err)
+	srtm, err := NewSrtmWithCustomStorage(http.DefaultClient, storage, WithMirrors(server.URL), WithCrawlResolutions(SRTM3))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"/SRTM3/": true}, requested)
+	assert.Empty(t, srtm.srtmData.Srtm1)
+	assert.Equal(t, 1, len(srtm.srtmData.Srtm3))
+
+	// SRTM1 is preferred, but not crawled:
+	baseUrl, srtmUrl := srtm.srtmData.GetBestSrtmUrl("N45E013")
+	assert.Equal(t, server.URL+"/SRTM3/", baseUrl)
+	assert.Equal(t, "N45E013", srtmUrl.Name)
+}
+
+type testMetrics struct {
+	NoopMetrics
+	lock   sync.Mutex
+	events []string
+}
+
+func (self *testMetrics) record(event string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.events = append(self.events, event)
+}
+
+func (self *testMetrics) CacheHit(tileName string)  { self.record("hit " + tileName) }
+func (self *testMetrics) CacheMiss(tileName string) { self.record("miss " + tileName) }
+func (self *testMetrics) DownloadStarted(fileUrl string) {
+	self.record("started " + path.Base(fileUrl))
+}
+func (self *testMetrics) DownloadCompleted(fileUrl string, bytes int, duration time.Duration) {
+	self.record(fmt.Sprintf("completed %s %d", path.Base(fileUrl), bytes))
+}
+func (self *testMetrics) DownloadFailed(fileUrl string, err error, duration time.Duration) {
+	self.record(fmt.Sprintf("failed %s %v", path.Base(fileUrl), errors.Is(err, ErrTileNotAvailable)))
+}
+func (self *testMetrics) InterpolationFallback(tileName string, from, to InterpolationMode) {
+	self.record(fmt.Sprintf("fallback %s %d %d", tileName, from, to))
+}
+
+func TestMetrics(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, -32768)
+	zipped := zipTile(t, "N45E013.hgt", contents)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "N46E013") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(zipped)
+	}))
+	defer server.Close()
+
+	metrics := &testMetrics{}
+	srtm := newTestSrtm(t, 0)
+	WithMetrics(metrics)(srtm)
+	WithInterpolation(InterpolationBilinear)(srtm)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}, {Name: "N46E013", Url: "N46E013.hgt.zip"}}
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	_, err = srtm.GetElevation(45.25, 13.25)
+	assert.Nil(t, err)
+	_, err = srtm.GetElevation(46.5, 13.5)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{
+		"miss N45E013",
+		"started N45E013.hgt.zip",
+		fmt.Sprintf("completed N45E013.hgt.zip %d", len(zipped)),
+		"fallback N45E013 1 0",
+		"hit N45E013",
+		"miss N46E013",
+		"started N46E013.hgt.zip",
+		"failed N46E013.hgt.zip true",
+	}, metrics.events)
+
+	// Without metrics, the events are ignored:
+	assert.Equal(t, NoopMetrics{}, metricsOrNoop(nil))
+}
+
+func TestLogLevel(t *testing.T) {
+	output := new(bytes.Buffer)
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	LogWarn.logf(LogDebug, "debug")
+	LogWarn.logf(LogError, "error")
+	assert.NotContains(t, output.String(), "debug")
+	assert.Contains(t, output.String(), "error")
+
+	// The level is used by the tiles too:
+	srtm := newTestSrtm(t, 100, "N45E013")
+	WithLogLevel(LogWarn)(srtm)
+	output.Reset()
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+	assert.Empty(t, output.String())
+
+	srtm = newTestSrtm(t, 100, "N45E013")
+	output.Reset()
+	_, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Contains(t, output.String(), "Loaded")
+
+	// Also for the storages created by the constructors:
+	output.Reset()
+	_, err = NewSrtmWithCustomCacheDir(http.DefaultClient, path.Join(t.TempDir(), "new"), WithSrtmData(&SrtmData{}), WithLogLevel(LogError))
+	assert.Nil(t, err)
+	_, err = NewSrtmWithFallbackCacheDirs(http.DefaultClient, []string{"/dev/null/srtm", t.TempDir()}, WithSrtmData(&SrtmData{}), WithLogLevel(LogError))
+	assert.Nil(t, err)
+	assert.Empty(t, output.String())
+	_, err = NewSrtmWithFallbackCacheDirs(http.DefaultClient, []string{"/dev/null/srtm", t.TempDir()}, WithSrtmData(&SrtmData{}), WithLogLevel(LogWarn))
+	assert.Nil(t, err)
+	assert.Contains(t, output.String(), "Can't use cache directory /dev/null/srtm")
+	assert.NotContains(t, output.String(), "Caching SRTM files in")
+
+	// And for the chain providers:
+	output.Reset()
+	chain := NewChainProvider(srtm).SetLogLevel(LogError)
+	_, err = chain.GetElevation(100, 13.5)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+	assert.Empty(t, output.String())
+	_, err = chain.SetLogLevel(LogWarn).GetElevation(100, 13.5)
+	assert.True(t, errors.Is(err, ErrInvalidCoordinate))
+	assert.Contains(t, output.String(), "Error retrieving elevation")
+}
+
+func TestZipHgtName(t *testing.T) {
+	srtmFile := newSrtmFile("N45E013", "http://localhost/N45E013.hgt.zip", SRTM3, 45, 13)
+	assert.Nil(t, srtmFile.setContents(zipTile(t, "n45e013.hgt", testTileContents(100))))
+	assert.Nil(t, srtmFile.setContents(zipTile(t, "SRTM3/N45E013.hgt", testTileContents(100))))
+	assert.Nil(t, srtmFile.setContents(zipTile(t, "dem.hgt", testTileContents(100))))
+
+	err := srtmFile.setContents(zipTile(t, "N47E008.hgt", testTileContents(100)))
+	assert.True(t, errors.Is(err, ErrCorruptTile))
+	assert.Contains(t, err.Error(), "contains N47E008.hgt instead of N45E013.hgt")
+
+	// A mirror serving the wrong tile is an error, the tile isn't used:
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(zipTile(t, "N47E008.hgt", testTileContents(100)))
+	}))
+	defer server.Close()
+	srtm := newTestSrtm(t, 0)
+	srtm.srtmData.Srtm3BaseUrl = server.URL + "/"
+	srtm.srtmData.Srtm3 = []SrtmUrl{{Name: "N45E013", Url: "N45E013.hgt.zip"}}
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.True(t, errors.Is(err, ErrCorruptTile))
+	assert.True(t, math.IsNaN(elevation))
+}
+
+func TestElevationFloor(t *testing.T) {
+	contents := testTileContents(100)
+	setTestSample(contents, 1201, 600, 600, -2)
+	// A void without valid samples around it (see WithVoidSearchLimit):
+	for _, cell := range [][2]int{{300, 300}, {299, 300}, {301, 300}, {300, 299}, {300, 301}} {
+		setTestSample(contents, 1201, cell[0], cell[1], -32768)
+	}
+	srtm := newTestSrtm(t, 0)
+	WithVoidSearchLimit(1)(srtm)
+	addTestTile(t, srtm, "N45E013", contents)
+
+	elevation, err := srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -2.0, elevation)
+
+	WithElevationFloor(-10)(srtm)
+	elevation, err = srtm.GetElevation(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -2.0, elevation)
+
+	WithElevationFloor(0)(srtm)
+	details, err := srtm.GetElevationDetails(45.5, 13.5)
+	assert.Nil(t, err)
+	assert.Equal(t, ElevationDetails{Elevation: 0, Clamped: true}, details)
+	elevation, err = srtm.GetElevation(45.25, 13.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, elevation)
+
+	// Unknown elevations stay unknown:
+	elevation, err = srtm.GetElevation(45.75, 13.25)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(elevation))
+}
@@ -0,0 +1,200 @@
+package geoelevations
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// STLVoids is how unknown elevations are handled in STL meshes
+type STLVoids int
+
+const (
+	// STLFillVoids uses the nearest known elevation
+	STLFillVoids STLVoids = iota
+	// STLSkipVoids leaves out the cells around voids, the holes are closed with walls down to the base
+	STLSkipVoids
+)
+
+type stlVertex [3]float32
+
+type stlTriangle struct {
+	normal   stlVertex
+	vertices [3]stlVertex
+}
+
+// ExportSTL writes the bounding box (with resolution samples per degree) as a solid (binary) STL mesh for 3D
+// printing: the terrain surface (in meters, elevations multiplied by verticalExaggeration) with walls and a flat
+// base below the lowest point. Voids are filled with the nearest known elevation.
+func (self *Srtm) ExportSTL(w io.Writer, minLat, minLon, maxLat, maxLon float64, resolution int, verticalExaggeration float64) error {
+	grid, err := self.GetGrid(minLat, minLon, maxLat, maxLon, resolution)
+	if err != nil {
+		return err
+	}
+	return grid.WriteSTL(w, verticalExaggeration, STLFillVoids)
+}
+
+// WriteSTL writes the grid as a solid (binary) STL mesh, see Srtm.ExportSTL. The grid cell centers are the
+// vertices of the mesh.
+func (self *ElevationGrid) WriteSTL(w io.Writer, verticalExaggeration float64, voids STLVoids) error {
+	triangles, err := self.stlTriangles(verticalExaggeration, voids)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(w)
+	header := make([]byte, 80)
+	copy(header, "go-elevations")
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, uint32(len(triangles))); err != nil {
+		return err
+	}
+	for _, triangle := range triangles {
+		if err := binary.Write(writer, binary.LittleEndian, triangle.normal); err != nil {
+			return err
+		}
+		if err := binary.Write(writer, binary.LittleEndian, triangle.vertices); err != nil {
+			return err
+		}
+		// Attribute byte count:
+		if err := binary.Write(writer, binary.LittleEndian, uint16(0)); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (self *ElevationGrid) stlTriangles(verticalExaggeration float64, voids STLVoids) ([]stlTriangle, error) {
+	if self.Rows < 2 || self.Columns < 2 {
+		return nil, errors.New(fmt.Sprintf("Grid too small for a mesh: %dx%d", self.Rows, self.Columns))
+	}
+
+	elevations := self.Elevations
+	if voids == STLFillVoids {
+		elevations = self.fillVoidsWithNearest()
+	}
+
+	base := math.Inf(1)
+	for _, elevation := range elevations {
+		if !math.IsNaN(elevation) {
+			base = math.Min(base, elevation*verticalExaggeration)
+		}
+	}
+	if math.IsInf(base, 1) {
+		return nil, errors.New("No known elevations in the grid")
+	}
+	// A few meters of solid below the lowest point:
+	base -= 10
+
+	latitude, _ := self.CellCenter(self.Rows/2, 0)
+	metersPerDegree := earthRadius * math.Pi / 180
+	dx := self.CellSize * metersPerDegree * math.Cos(toRadians(latitude))
+	dy := self.CellSize * metersPerDegree
+	top := func(row, column int) stlVertex {
+		return stlVertex{float32(float64(column) * dx), float32(float64(self.Rows-1-row) * dy), float32(elevations[row*self.Columns+column] * verticalExaggeration)}
+	}
+	bottom := func(row, column int) stlVertex {
+		return stlVertex{float32(float64(column) * dx), float32(float64(self.Rows-1-row) * dy), float32(base)}
+	}
+
+	// Cells are the quads between 4 grid points:
+	cellRows, cellColumns := self.Rows-1, self.Columns-1
+	included := func(row, column int) bool {
+		if row < 0 || column < 0 || row >= cellRows || column >= cellColumns {
+			return false
+		}
+		for _, corner := range [][2]int{{row, column}, {row, column + 1}, {row + 1, column}, {row + 1, column + 1}} {
+			if math.IsNaN(elevations[corner[0]*self.Columns+corner[1]]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var triangles []stlTriangle
+	quad := func(a, b, c, d stlVertex, outward stlVertex) {
+		triangles = append(triangles, newStlTriangle(a, b, c, outward), newStlTriangle(a, c, d, outward))
+	}
+	for row := 0; row < cellRows; row++ {
+		for column := 0; column < cellColumns; column++ {
+			if !included(row, column) {
+				continue
+			}
+			quad(top(row+1, column), top(row+1, column+1), top(row, column+1), top(row, column), stlVertex{0, 0, 1})
+			quad(bottom(row+1, column), bottom(row+1, column+1), bottom(row, column+1), bottom(row, column), stlVertex{0, 0, -1})
+
+			// Walls where the neighbouring cell is not in the mesh:
+			if !included(row-1, column) {
+				quad(top(row, column), top(row, column+1), bottom(row, column+1), bottom(row, column), stlVertex{0, 1, 0})
+			}
+			if !included(row+1, column) {
+				quad(top(row+1, column), top(row+1, column+1), bottom(row+1, column+1), bottom(row+1, column), stlVertex{0, -1, 0})
+			}
+			if !included(row, column-1) {
+				quad(top(row, column), top(row+1, column), bottom(row+1, column), bottom(row, column), stlVertex{-1, 0, 0})
+			}
+			if !included(row, column+1) {
+				quad(top(row, column+1), top(row+1, column+1), bottom(row+1, column+1), bottom(row, column+1), stlVertex{1, 0, 0})
+			}
+		}
+	}
+
+	return triangles, nil
+}
+
+// newStlTriangle orders the vertices counter-clockwise when seen from the outward direction
+func newStlTriangle(a, b, c stlVertex, outward stlVertex) stlTriangle {
+	normal := stlCross(stlSub(b, a), stlSub(c, a))
+	if normal[0]*outward[0]+normal[1]*outward[1]+normal[2]*outward[2] < 0 {
+		b, c = c, b
+		normal = stlVertex{-normal[0], -normal[1], -normal[2]}
+	}
+	length := float32(math.Sqrt(float64(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2])))
+	if length > 0 {
+		normal = stlVertex{normal[0] / length, normal[1] / length, normal[2] / length}
+	}
+	return stlTriangle{normal: normal, vertices: [3]stlVertex{a, b, c}}
+}
+
+func stlSub(a, b stlVertex) stlVertex {
+	return stlVertex{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func stlCross(a, b stlVertex) stlVertex {
+	return stlVertex{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// fillVoidsWithNearest returns the elevations with the unknown ones replaced by the nearest (in cells) known one
+func (self *ElevationGrid) fillVoidsWithNearest() []float64 {
+	result := append([]float64(nil), self.Elevations...)
+
+	// Breadth-first search from all the known cells at once:
+	queue := make([]int, 0, len(result))
+	for i, elevation := range result {
+		if !math.IsNaN(elevation) {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		row, column := i/self.Columns, i%self.Columns
+		for _, neighbour := range [][2]int{{row - 1, column}, {row + 1, column}, {row, column - 1}, {row, column + 1}} {
+			if neighbour[0] < 0 || neighbour[1] < 0 || neighbour[0] >= self.Rows || neighbour[1] >= self.Columns {
+				continue
+			}
+			j := neighbour[0]*self.Columns + neighbour[1]
+			if math.IsNaN(result[j]) {
+				result[j] = result[i]
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	return result
+}
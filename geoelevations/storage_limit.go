@@ -0,0 +1,109 @@
+package geoelevations
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storageAccessFileName is the file (in the storage) with the last time every cached tile was loaded
+const storageAccessFileName = "access.json"
+
+// tileLoaded records that the tile was loaded (from the storage or server) and deletes the least recently loaded
+// tiles from the storage if over the limit. Must be called with the lock held.
+func (self *Srtm) tileLoaded(srtmFile *SrtmFile) {
+	if self.maxStorageBytes <= 0 {
+		return
+	}
+	listingStorage, ok := self.storage.(SrtmListingStorage)
+	if !ok {
+		return
+	}
+
+	if self.storageAccess == nil {
+		self.storageAccess = map[string]time.Time{}
+		if bytes, err := self.storage.LoadFile(storageAccessFileName); err == nil {
+			if err := json.Unmarshal(bytes, &self.storageAccess); err != nil {
+				self.logLevel.logf(LogWarn, "Invalid %s: %s", storageAccessFileName, err.Error())
+			}
+		}
+	}
+	self.storageAccess[srtmFile.storageName] = time.Now()
+
+	if err := self.limitStorage(listingStorage, srtmFile.storageName); err != nil {
+		self.logLevel.logf(LogError, "Error limiting the storage size: %s", err.Error())
+	}
+
+	bytes, err := json.Marshal(self.storageAccess)
+	if err == nil {
+		err = self.storage.SaveFile(storageAccessFileName, bytes)
+	}
+	if err != nil {
+		self.logLevel.logf(LogError, "Error saving %s: %s", storageAccessFileName, err.Error())
+	}
+}
+
+// limitStorage deletes the least recently loaded tiles (except keep, the storage name of a tile) until the cached
+// tiles fit in the limit
+func (self *Srtm) limitStorage(storage SrtmListingStorage, keep string) error {
+	files, err := storage.List()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	tileFiles := map[string][]string{}
+	for fn, size := range files {
+		// Storage names can have a directory (see TileFileNamer), but the file name always starts with the tile name:
+		directory, base := path.Split(fn)
+		tileName := strings.SplitN(base, ".", 2)[0]
+		if _, _, _, _, ok := TileBounds(tileName); !ok || !strings.HasPrefix(base[len(tileName):], ".hgt") {
+			continue
+		}
+		name := directory + tileName
+		tileFiles[name] = append(tileFiles[name], fn)
+		total += size
+	}
+	if total <= self.maxStorageBytes {
+		return nil
+	}
+
+	names := make([]string, 0, len(tileFiles))
+	for name := range tileFiles {
+		if name != keep {
+			names = append(names, name)
+		}
+	}
+	// Tiles never loaded (since the access times are recorded) first, tiles in memory last:
+	sort.Slice(names, func(i, j int) bool {
+		iLoaded, jLoaded := self.isTileLoaded(names[i]), self.isTileLoaded(names[j])
+		if iLoaded != jLoaded {
+			return jLoaded
+		}
+		return self.storageAccess[names[i]].Before(self.storageAccess[names[j]])
+	})
+
+	for _, name := range names {
+		if total <= self.maxStorageBytes {
+			break
+		}
+		for _, fn := range tileFiles[name] {
+			if err := storage.Delete(fn); err != nil {
+				return err
+			}
+			total -= files[fn]
+		}
+		delete(self.storageAccess, name)
+		self.logLevel.logf(LogInfo, "Deleted %s from the storage, %d bytes left", name, total)
+	}
+
+	return nil
+}
+
+// isTileLoaded checks if the tile cached with the storage name is in memory
+func (self *Srtm) isTileLoaded(storageName string) bool {
+	srtmFile, found := self.cache[path.Base(storageName)]
+	return found && srtmFile.storageName == storageName && srtmFile.isLoaded()
+}
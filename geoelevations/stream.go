@@ -0,0 +1,83 @@
+package geoelevations
+
+import (
+	"context"
+	"sort"
+)
+
+// streamBatchSize is the max number of points GetElevationsStream reads (and groups by tile) before computing
+// their elevations
+const streamBatchSize = 1024
+
+// ElevationResult is the elevation of a point streamed by GetElevationsStream
+type ElevationResult struct {
+	Point     Point
+	Elevation float64
+	Err       error
+}
+
+// GetElevationsStream returns the elevations of the points read from in. Points already waiting in the channel are
+// grouped by tile, so results are not necessarily in the order of the points. The output channel is closed when in
+// is closed (and all the results sent), or when the context is done.
+func (self *Srtm) GetElevationsStream(ctx context.Context, in <-chan Point) <-chan ElevationResult {
+	out := make(chan ElevationResult)
+	go func() {
+		defer close(out)
+		for {
+			batch, more := readPointsBatch(ctx, in, streamBatchSize)
+			tiles := make([]streamPoint, len(batch))
+			for n, point := range batch {
+				tiles[n].point = point
+				tiles[n].tile, _, _ = self.getSrtmFileNameAndCoordinates(point.Lat, point.Lon)
+			}
+			sort.SliceStable(tiles, func(i, j int) bool {
+				return tiles[i].tile < tiles[j].tile
+			})
+			for _, tile := range tiles {
+				elevation, err := self.GetElevationContext(ctx, tile.point.Lat, tile.point.Lon)
+				select {
+				case out <- ElevationResult{Point: tile.point, Elevation: elevation, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !more || ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// streamPoint is a point of a GetElevationsStream batch, with the name of its tile
+type streamPoint struct {
+	point Point
+	tile  string
+}
+
+// readPointsBatch waits for the first point, then reads up to size points (without waiting). more is false if the
+// channel is closed or the context is done.
+func readPointsBatch(ctx context.Context, in <-chan Point, size int) (batch []Point, more bool) {
+	select {
+	case point, ok := <-in:
+		if !ok {
+			return nil, false
+		}
+		batch = append(batch, point)
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	for len(batch) < size {
+		select {
+		case point, ok := <-in:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, point)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
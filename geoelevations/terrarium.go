@@ -0,0 +1,60 @@
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// WriteTerrariumPNG writes the web mercator tile z/x/y (size x size pixels) as a PNG image in the Terrarium
+// encoding (elevation = R*256 + G + B/256 - 32768). Unknown elevations are encoded as 0.
+func (self *Srtm) WriteTerrariumPNG(w io.Writer, z, x, y, size int) error {
+	img, err := self.TerrariumImage(z, x, y, size)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// TerrariumImage is like WriteTerrariumPNG, but returns the image
+func (self *Srtm) TerrariumImage(z, x, y, size int) (*image.RGBA, error) {
+	if z < 0 || z > 30 || x < 0 || y < 0 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		return nil, errors.New(fmt.Sprintf("Invalid tile %d/%d/%d", z, x, y))
+	}
+	if size <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid tile size: %d", size))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	tiles := float64(int(1) << uint(z))
+	for py := 0; py < size; py++ {
+		latitude := toDegrees(math.Atan(math.Sinh(math.Pi * (1 - 2*(float64(y)+(float64(py)+0.5)/float64(size))/tiles))))
+		for px := 0; px < size; px++ {
+			longitude := (float64(x)+(float64(px)+0.5)/float64(size))/tiles*360 - 180
+			elevation, err := self.getSampledElevation(latitude, longitude)
+			if err != nil {
+				return nil, err
+			}
+			img.SetRGBA(px, py, encodeTerrarium(elevation))
+		}
+	}
+
+	return img, nil
+}
+
+func encodeTerrarium(elevation float64) color.RGBA {
+	if math.IsNaN(elevation) {
+		elevation = 0
+	}
+	value := math.Max(0, math.Min(elevation+32768, 65536-1.0/256))
+	return color.RGBA{
+		R: uint8(int(value) / 256),
+		G: uint8(int(value) % 256),
+		B: uint8((value - math.Floor(value)) * 256),
+		A: 0xff,
+	}
+}
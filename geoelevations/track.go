@@ -0,0 +1,82 @@
+package geoelevations
+
+import (
+	"fmt"
+	"math"
+)
+
+// GetElevations returns the elevations of points ({latitude, longitude} pairs). If some tiles fail, the elevations
+// of their points are NaN and the error is an *ElevationsError (with the other points still in the result).
+func (self *Srtm) GetElevations(points [][2]float64) ([]float64, error) {
+	result := make([]float64, len(points))
+	errs := map[string]error{}
+	for n, point := range points {
+		var name string
+		if validateCoordinates(point[0], point[1]) == nil {
+			name, _, _ = self.getSrtmFileNameAndCoordinates(normalizeCoordinates(point[0], point[1]))
+		} else {
+			name = fmt.Sprintf("%v,%v", point[0], point[1])
+		}
+		// Don't retry a failed tile for every point in it:
+		if _, failed := errs[name]; failed {
+			result[n] = math.NaN()
+			continue
+		}
+		elevation, err := self.GetElevation(point[0], point[1])
+		if err != nil {
+			errs[name] = err
+		}
+		result[n] = elevation
+	}
+	if len(errs) > 0 {
+		return result, &ElevationsError{Errors: errs}
+	}
+	return result, nil
+}
+
+// GetElevationsForPoints is like GetElevations, with Point coordinates
+func (self *Srtm) GetElevationsForPoints(points []Point) ([]float64, error) {
+	return self.GetElevations(pointPairs(points))
+}
+
+// TrackElevationGain returns the cumulative elevation gain and loss along the track points ({latitude, longitude}
+// pairs). Changes smaller than smoothingThreshold (in meters) are ignored as SRTM noise: the elevation must move
+// at least smoothingThreshold from the last counted elevation before the difference is added. Points without an
+// elevation (NaN) are skipped.
+func (self *Srtm) TrackElevationGain(points [][2]float64, smoothingThreshold float64) (gain, loss float64, err error) {
+	elevations, err := self.GetElevations(points)
+	if err != nil {
+		return 0, 0, err
+	}
+	gain, loss = elevationGain(elevations, smoothingThreshold)
+	return gain, loss, nil
+}
+
+// TrackElevationGainForPoints is like TrackElevationGain, with Point coordinates
+func (self *Srtm) TrackElevationGainForPoints(points []Point, smoothingThreshold float64) (gain, loss float64, err error) {
+	return self.TrackElevationGain(pointPairs(points), smoothingThreshold)
+}
+
+func elevationGain(elevations []float64, smoothingThreshold float64) (gain, loss float64) {
+	reference := math.NaN()
+	for _, elevation := range elevations {
+		if math.IsNaN(elevation) {
+			continue
+		}
+		if math.IsNaN(reference) {
+			reference = elevation
+			continue
+		}
+		delta := elevation - reference
+		if math.Abs(delta) < smoothingThreshold {
+			continue
+		}
+		if delta > 0 {
+			gain += delta
+		} else {
+			loss -= delta
+		}
+		reference = elevation
+	}
+	return gain, loss
+}
@@ -4,13 +4,48 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"strings"
+)
+
+// maxTileBytes is the size of the largest (SRTM1) tiles
+const maxTileBytes = 3601 * 3601 * 2
 
-	"io/ioutil"
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
 )
 
+func isZipBytes(byts []byte) bool {
+	return bytes.HasPrefix(byts, zipMagic)
+}
+
+func isGzipBytes(byts []byte) bool {
+	return bytes.HasPrefix(byts, gzipMagic)
+}
+
+func isHtmlBytes(byts []byte) bool {
+	if len(byts) > 512 {
+		byts = byts[:512]
+	}
+	start := strings.ToLower(strings.TrimSpace(string(byts)))
+	return strings.HasPrefix(start, "<html") || strings.HasPrefix(start, "<!doctype html")
+}
+
+// bytesPrefix describes the first bytes, for error messages about unexpected file contents
+func bytesPrefix(byts []byte) string {
+	if len(byts) == 0 {
+		return "nothing (empty file)"
+	}
+	if len(byts) > 16 {
+		return fmt.Sprintf("%q...", byts[:16])
+	}
+	return fmt.Sprintf("%q", byts)
+}
+
 func gzipBytes(b *[]byte) (*[]byte, error) {
 	buf := new(bytes.Buffer)
 	gz := gzip.NewWriter(buf)
@@ -31,45 +66,110 @@ func gzipBytes(b *[]byte) (*[]byte, error) {
 }
 
 func ungzipBytes(b *[]byte) (*[]byte, error) {
-	r, err := gzip.NewReader(ioutil.NopCloser(bytes.NewBuffer(*b)))
+	bb, err := ungzipReader(bytes.NewBuffer(*b), gzipSizeHint(bytes.NewReader(*b), int64(len(*b))))
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
+	return &bb, nil
+}
 
-	bb, err := ioutil.ReadAll(r)
+// ungzipReader decompresses the gzipped reader, sizeHint is the expected size (see gzipSizeHint) or 0 if unknown
+func ungzipReader(reader io.Reader, sizeHint int) ([]byte, error) {
+	r, err := gzip.NewReader(reader)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 
-	return &bb, nil
+	return readAllPresized(r, sizeHint)
 }
 
-func unzipBytes(byts []byte) ([]byte, error) {
-	r, err := zip.NewReader(bytes.NewReader(byts), int64(len(byts)))
+// gzipSizeHint returns the uncompressed size from the trailer of the gzipped file (of size bytes), or 0 if unknown.
+// The trailer has the size modulo 4GB and can be wrong in a corrupt file, so it's only a hint (and ignored if larger
+// than a tile).
+func gzipSizeHint(reader io.ReaderAt, size int64) int {
+	trailer := make([]byte, 4)
+	if size < int64(len(trailer)) {
+		return 0
+	}
+	if _, err := reader.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return 0
+	}
+	sizeHint := int(binary.LittleEndian.Uint32(trailer))
+	if sizeHint > maxTileBytes {
+		return 0
+	}
+	return sizeHint
+}
+
+// readAllPresized reads everything into a buffer presized to sizeHint bytes (if known), so that loading a tile
+// (up to 25MB for SRTM1) doesn't grow and copy it repeatedly
+func readAllPresized(reader io.Reader, sizeHint int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if sizeHint > 0 {
+		buf.Grow(sizeHint + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnzipHGT extracts the .hgt file from a (.hgt.zip) archive. The result is the raw grid of the tile, big-endian
+// signed 16-bit samples row by row from the north-west corner.
+func UnzipHGT(data []byte) ([]byte, error) {
+	return unzipBytes(data)
+}
+
+// findZipHgt returns the (only) .hgt file in the .zip archive
+func findZipHgt(reader io.ReaderAt, size int64) (*zip.File, error) {
+	r, err := zip.NewReader(reader, size)
 	if err != nil {
 		return nil, err
 	}
 
-	// Iterate through the files in the archive,
-	// printing some of their contents.
+	// Archives can contain other files (readme, checksums) and directories besides the .hgt:
+	var hgt *zip.File
 	for _, f := range r.File {
-		fmt.Printf("Contents of %s:\n", f.Name)
-		rc, err := f.Open()
-		if err != nil {
-			log.Printf("Error reading %s: %s", f.Name, err.Error())
-			return nil, err
+		if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".hgt") {
+			continue
 		}
-		defer rc.Close()
-
-		bytes, err := ioutil.ReadAll(rc)
-		if err != nil {
-			log.Printf("Error reading %s: %s", f.Name, err.Error())
-			return nil, err
+		if hgt != nil {
+			return nil, errors.New(fmt.Sprintf("More than one .hgt file in .zip: %s, %s", hgt.Name, f.Name))
 		}
+		hgt = f
+	}
+	if hgt == nil {
+		return nil, errors.New(fmt.Sprintf("No .hgt file in .zip"))
+	}
+	return hgt, nil
+}
 
-		return bytes, nil
+func unzipBytes(byts []byte) ([]byte, error) {
+	return unzipReaderAt(bytes.NewReader(byts), int64(len(byts)))
+}
+
+func unzipReaderAt(reader io.ReaderAt, size int64) ([]byte, error) {
+	hgt, err := findZipHgt(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := hgt.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %w", hgt.Name, err)
+	}
+	defer rc.Close()
+
+	// The size in the header is only a hint, a corrupt archive can have a wrong (or huge) one:
+	sizeHint := 0
+	if hgt.UncompressedSize64 <= maxTileBytes {
+		sizeHint = int(hgt.UncompressedSize64)
+	}
+	bytes, err := readAllPresized(rc, sizeHint)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %w", hgt.Name, err)
 	}
 
-	return nil, errors.New(fmt.Sprintf("No file in .zip"))
+	return bytes, nil
 }
@@ -0,0 +1,55 @@
+package geoelevations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const defaultLineOfSightSpacing = 30.0
+
+// LineOfSight checks if the straight line between the two points (with the observer and target heights above
+// the ground, in meters) clears the terrain everywhere. The terrain is sampled along the great-circle path
+// every few meters (see WithLineOfSightSpacing), and the curvature of the Earth is taken into account. Samples
+// without an elevation (voids, missing tiles) are ignored.
+func (self *Srtm) LineOfSight(fromLat, fromLon, fromHeight, toLat, toLon, toHeight float64) (bool, error) {
+	fromElevation, err := self.GetElevation(fromLat, fromLon)
+	if err != nil {
+		return false, err
+	}
+	toElevation, err := self.GetElevation(toLat, toLon)
+	if err != nil {
+		return false, err
+	}
+	if math.IsNaN(fromElevation) || math.IsNaN(toElevation) {
+		return false, errors.New(fmt.Sprintf("Unknown elevation of (%f, %f) or (%f, %f)", fromLat, fromLon, toLat, toLon))
+	}
+	from := fromElevation + fromHeight
+	to := toElevation + toHeight
+
+	spacing := self.lineOfSightSpacing
+	if spacing <= 0 {
+		spacing = defaultLineOfSightSpacing
+	}
+	distance := haversineDistance(fromLat, fromLon, toLat, toLon)
+	points := Densify(Point{Lat: fromLat, Lon: fromLon}, Point{Lat: toLat, Lon: toLon}, spacing)
+
+	for n := 1; n < len(points)-1; n++ {
+		fraction := float64(n) / float64(len(points)-1)
+		elevation, err := self.getSampledElevation(points[n].Lat, points[n].Lon)
+		if err != nil {
+			return false, err
+		}
+		if math.IsNaN(elevation) {
+			continue
+		}
+
+		// The terrain "bulges" into the straight line because of the Earth curvature:
+		bulge := (fraction * distance) * ((1 - fraction) * distance) / (2 * earthRadius)
+		if elevation+bulge > from+(to-from)*fraction {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}